@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type battletagHistory struct {
+	Battletag string
+	FirstSeen time.Time `db:"first_seen"`
+	LastSeen  time.Time `db:"last_seen"`
+}
+
+// updateBattletags adds the battletags of players of games with IDs in
+// [start, end) to the battletags table.
+func (h *hotsContext) updateBattletags(start, end int64) error {
+	return retry(func() error {
+		_, err := h.db.Exec(`
+			INSERT INTO battletags (region, blizzid, battletag, first_seen, last_seen)
+			SELECT region, blizzid, battletag, min(time), max(time)
+			FROM players
+			WHERE game >= $1 AND game < $2 AND battletag IS NOT NULL
+			GROUP BY region, blizzid, battletag
+			ON CONFLICT (region, blizzid, battletag) DO UPDATE SET
+				first_seen = least(battletags.first_seen, excluded.first_seen),
+				last_seen = greatest(battletags.last_seen, excluded.last_seen)
+			`, start, end)
+		return errors.Wrap(err, "update battletags")
+	})
+}
+
+// updateAllBattletags runs updateBattletags over all games.
+func (h *hotsContext) updateAllBattletags() error {
+	return h.eachBlock("updating battletags", h.updateBattletags)
+}
+
+// getBattletags returns the current battletags of blizzids in a region.
+func (h *hotsContext) getBattletags(ctx context.Context, region string, blizzids []string) (map[string]string, error) {
+	res := make(map[string]string, len(blizzids))
+	if len(blizzids) == 0 {
+		return res, nil
+	}
+	args := []interface{}{region}
+	for _, b := range blizzids {
+		args = append(args, b)
+	}
+	var rows []struct {
+		Blizzid   string
+		Battletag string
+	}
+	if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+		SELECT DISTINCT ON (blizzid) blizzid, battletag
+		FROM battletags
+		WHERE region = $1 AND blizzid IN %s
+		ORDER BY blizzid, last_seen DESC
+		`, makeValues(len(blizzids), 2)), args...); err != nil {
+		return nil, errors.Wrap(err, "get battletags")
+	}
+	for _, r := range rows {
+		res[r.Blizzid] = r.Battletag
+	}
+	return res, nil
+}
+
+// getBattletagHistory returns all battletags of a player, newest first.
+func (h *hotsContext) getBattletagHistory(ctx context.Context, blizzid, region string) ([]battletagHistory, error) {
+	var res []battletagHistory
+	err := h.x.SelectContext(ctx, &res, `
+		SELECT battletag, first_seen, last_seen
+		FROM battletags
+		WHERE region = $1 AND blizzid = $2
+		ORDER BY last_seen DESC
+		`, region, blizzid)
+	return res, errors.Wrap(err, "battletag history")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// comboMinTeams is the minimum number of teams a combo must be seen on.
+	comboMinTeams = 100
+	// comboLimit is the maximum number of combos of each size stored per
+	// build and mode.
+	comboLimit = 200
+	// comboZ is the z-score of the winrate confidence intervals (95%).
+	comboZ = 1.96
+)
+
+// comboSizes are the numbers of heroes in the mined combos. Pairs are
+// already covered by GetCompareHero.
+var comboSizes = []int{3, 4}
+
+type heroCombo struct {
+	Heroes []string
+	Total
+	// Lift is how many times more often the heroes were on the same team
+	// than expected if each were picked independently.
+	Lift float64
+	// WinrateLow and WinrateHigh are the bounds of the Wilson score
+	// interval of the winrate.
+	Winrate     float64
+	WinrateLow  float64
+	WinrateHigh float64
+}
+
+type heroCombos struct {
+	Teams  int
+	Combos map[string][]heroCombo
+}
+
+/*
+heroCombos finds hero trios and quads that were on the same team more often
+than expected for each mode of the newest builds. Mode 0 is all modes.
+Results are stored in the combos table.
+*/
+func (h *hotsContext) heroCombos() error {
+	ctx := context.Background()
+	if err := h.updateInit(ctx); err != nil {
+		return err
+	}
+	init := h.getInit()
+	for _, b := range init.recentBuilds() {
+		start := time.Now()
+		patch := init.config.build(b.ID)
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT game, mode, team, winner, hero
+			FROM players
+			WHERE build = $1 AND NOT leaver
+			`, patch)
+		if err != nil {
+			return errors.Wrap(err, "fetch teams")
+		}
+		type team struct {
+			game int64
+			team int
+		}
+		type teamHeroes struct {
+			mode   Mode
+			winner bool
+			heroes []int
+		}
+		teams := make(map[team]*teamHeroes)
+		for rows.Next() {
+			var t team
+			var mode Mode
+			var winner bool
+			var hero int
+			if err := rows.Scan(&t.game, &mode, &t.team, &winner, &hero); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan")
+			}
+			th := teams[t]
+			if th == nil {
+				th = &teamHeroes{mode: mode, winner: winner}
+				teams[t] = th
+			}
+			th.heroes = append(th.heroes, hero)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "rows")
+		}
+		fmt.Println("hero combos", b.ID, "fetched", len(teams), "teams", time.Since(start))
+		miners := make(map[Mode]*comboMiner)
+		for _, th := range teams {
+			sort.Ints(th.heroes)
+			for _, m := range []Mode{th.mode, 0} {
+				cm := miners[m]
+				if cm == nil {
+					cm = newComboMiner()
+					miners[m] = cm
+				}
+				cm.add(th.heroes, th.winner)
+			}
+		}
+		for mode, cm := range miners {
+			data, err := json.Marshal(cm.result(init.lookups["hero"]))
+			if err != nil {
+				return err
+			}
+			if err := retry(func() error {
+				_, err := h.db.Exec(`UPSERT INTO combos (build, mode, data) VALUES ($1, $2, $3)`,
+					patch, mode, data,
+				)
+				return err
+			}); err != nil {
+				return errors.Wrap(err, "upsert combos")
+			}
+		}
+		fmt.Println("hero combos", b.ID, "took", time.Since(start))
+	}
+	return nil
+}
+
+// comboMiner counts heroes and hero combos on teams.
+type comboMiner struct {
+	teams  int
+	heroes map[int]int
+	combos map[string]*Total
+}
+
+func newComboMiner() *comboMiner {
+	return &comboMiner{
+		heroes: make(map[int]int),
+		combos: make(map[string]*Total),
+	}
+}
+
+// add counts a team. heroes must be sorted.
+func (c *comboMiner) add(heroes []int, winner bool) {
+	c.teams++
+	for _, h := range heroes {
+		c.heroes[h]++
+	}
+	for _, size := range comboSizes {
+		eachCombo(heroes, size, func(combo []int) {
+			k := comboKey(combo)
+			t := c.combos[k]
+			if t == nil {
+				t = new(Total)
+				c.combos[k] = t
+			}
+			if winner {
+				t.Wins++
+			} else {
+				t.Losses++
+			}
+		})
+	}
+}
+
+// result returns the combos with the highest lift of each size, keyed by
+// size.
+func (c *comboMiner) result(heroName func(string) string) heroCombos {
+	res := heroCombos{
+		Teams:  c.teams,
+		Combos: make(map[string][]heroCombo),
+	}
+	n := float64(c.teams)
+	for k, t := range c.combos {
+		count := t.Wins + t.Losses
+		if count < comboMinTeams {
+			continue
+		}
+		ids := strings.Split(k, ",")
+		expected := n
+		hc := heroCombo{Total: *t}
+		for _, id := range ids {
+			h, _ := strconv.Atoi(id)
+			expected *= float64(c.heroes[h]) / n
+			hc.Heroes = append(hc.Heroes, heroName(id))
+		}
+		hc.Lift = float64(count) / expected
+		hc.Winrate, hc.WinrateLow, hc.WinrateHigh = wilson(t.Wins, count)
+		size := strconv.Itoa(len(ids))
+		res.Combos[size] = append(res.Combos[size], hc)
+	}
+	for size, combos := range res.Combos {
+		sort.Slice(combos, func(i, j int) bool {
+			return combos[i].Lift > combos[j].Lift
+		})
+		if len(combos) > comboLimit {
+			res.Combos[size] = combos[:comboLimit]
+		}
+	}
+	return res
+}
+
+// eachCombo calls fn with each combination of size elements of s. The
+// slice passed to fn is reused.
+func eachCombo(s []int, size int, fn func([]int)) {
+	combo := make([]int, size)
+	var rec func(start, depth int)
+	rec = func(start, depth int) {
+		if depth == size {
+			fn(combo)
+			return
+		}
+		for i := start; i <= len(s)-(size-depth); i++ {
+			combo[depth] = s[i]
+			rec(i+1, depth+1)
+		}
+	}
+	rec(0, 0)
+}
+
+func comboKey(heroes []int) string {
+	strs := make([]string, len(heroes))
+	for i, h := range heroes {
+		strs[i] = strconv.Itoa(h)
+	}
+	return strings.Join(strs, ",")
+}
+
+// wilson returns the winrate and its Wilson score interval.
+func wilson(wins, n int) (p, low, high float64) {
+	if n == 0 {
+		return 0, 0, 0
+	}
+	fn := float64(n)
+	p = float64(wins) / fn
+	z2 := comboZ * comboZ
+	center := (p + z2/(2*fn)) / (1 + z2/fn)
+	margin := comboZ * math.Sqrt(p*(1-p)/fn+z2/(4*fn*fn)) / (1 + z2/fn)
+	return p, center - margin, center + margin
+}
+
+// GetHeroCombos returns the hero combos computed by heroCombos. If hero is
+// set only combos including it are returned.
+func (h *hotsContext) GetHeroCombos(ctx context.Context, r *http.Request) (interface{}, error) {
+	init := h.getInit()
+	build := r.FormValue("build")
+	if build == "" {
+		return nil, errors.New("build required")
+	}
+	patch := init.config.Map["build"][build]
+	if patch == "" {
+		return nil, errors.Errorf("unrecognized build: %s", build)
+	}
+	mode := r.FormValue("mode")
+	if mode == "" {
+		mode = "0"
+	}
+	var data []byte
+	if err := h.x.GetContext(ctx, &data, `
+		SELECT data
+		FROM combos
+		WHERE build = $1 AND mode = $2
+		`, patch, mode); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hero := r.FormValue("hero")
+	if hero == "" {
+		return json.RawMessage(data), nil
+	}
+	var res heroCombos
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	for size, combos := range res.Combos {
+		var filtered []heroCombo
+		for _, c := range combos {
+			for _, name := range c.Heroes {
+				if name == hero {
+					filtered = append(filtered, c)
+					break
+				}
+			}
+		}
+		res.Combos[size] = filtered
+	}
+	return res, nil
+}
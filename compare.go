@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// statQuantiles is the number of quantile steps stored per stat by
+// heroStatDists.
+const statQuantiles = 100
+
+// statPerMinute returns the SQL expression of a score stat per minute.
+func statPerMinute(stat string) string {
+	return fmt.Sprintf("COALESCE((data->>'%s')::FLOAT, 0) * 60 / greatest(length, 1)::FLOAT", stat)
+}
+
+type playerSide struct {
+	Region    string
+	Blizzid   string
+	Battletag string
+	Heroes    map[string]Total
+	Roles     map[string]Total
+	Skills    []struct {
+		Build string
+		Mode  Mode
+		Skill float64
+	}
+	// Percentiles are, per score stat, the average percentile (from 0 to 1)
+	// of the player's per minute value among all games on the same hero and
+	// build. Only builds with stored distributions (see heroStatDists) are
+	// used.
+	Percentiles map[string]float64
+}
+
+type sharedGame struct {
+	Game   int64
+	Time   time.Time
+	Map    string
+	Mode   Mode
+	Build  string
+	Length int
+	Hero1  string
+	Hero2  string
+	// Winner is whether the first player won.
+	Winner bool
+}
+
+// GetPlayerCompare compares two players side by side and returns the games
+// they played with and against each other. The players are given by
+// region1, blizzid1, region2 and blizzid2.
+func (h *hotsContext) GetPlayerCompare(ctx context.Context, r *http.Request) (interface{}, error) {
+	var ids [2]struct{ region, blizzid string }
+	for i := range ids {
+		ids[i].region = r.FormValue(fmt.Sprintf("region%d", i+1))
+		ids[i].blizzid = r.FormValue(fmt.Sprintf("blizzid%d", i+1))
+		if ids[i].region == "" || ids[i].blizzid == "" {
+			return nil, errors.Errorf("region%d and blizzid%d required", i+1, i+1)
+		}
+	}
+	init := h.getInit()
+	args := map[string]string{
+		"build": r.FormValue("build"),
+		"from":  r.FormValue("from"),
+		"to":    r.FormValue("to"),
+	}
+
+	var res struct {
+		Players  [2]*playerSide
+		Together struct {
+			Total
+			Games []sharedGame
+		}
+		Against struct {
+			Total
+			Games []sharedGame
+		}
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, id := range ids {
+		i, id := i, id
+		g.Go(func() error {
+			var err error
+			res.Players[i], err = h.getPlayerSide(gCtx, init, id.region, id.blizzid, args)
+			return errors.Wrapf(err, "player %d", i+1)
+		})
+	}
+	if ids[0].region == ids[1].region {
+		g.Go(func() error {
+			wheres := []string{"region = $1", "blizzid = $2"}
+			params := []interface{}{ids[0].region, ids[0].blizzid}
+			if err := setBuildParams(init, &wheres, &params, args); err != nil {
+				return err
+			}
+			params = append(params, ids[1].blizzid)
+			var games []struct {
+				sharedGame
+				Together bool
+			}
+			if err := h.x.SelectContext(gCtx, &games, fmt.Sprintf(`
+				SELECT
+					p.game, p.time, p.map, p.mode, p.build, p.length,
+					p.hero AS hero1, o.hero AS hero2, p.winner,
+					p.team = o.team AS together
+				FROM
+					(
+						SELECT game, time, map, mode, build, length, hero, winner, team, region
+						FROM players
+						WHERE %s
+					) AS p
+					JOIN players AS o ON
+						o.game = p.game
+						AND o.region = p.region
+						AND o.blizzid = $%d
+				ORDER BY p.time DESC
+				`, strings.Join(wheres, " AND "), len(params)), params...); err != nil {
+				return errors.Wrap(err, "shared games")
+			}
+			for _, sg := range games {
+				sg.Map = init.lookups["map"](sg.Map)
+				sg.Build = init.lookups["build"](sg.Build)
+				sg.Hero1 = init.lookups["hero"](sg.Hero1)
+				sg.Hero2 = init.lookups["hero"](sg.Hero2)
+				t := &res.Against.Total
+				list := &res.Against.Games
+				if sg.Together {
+					t = &res.Together.Total
+					list = &res.Together.Games
+				}
+				if sg.Winner {
+					t.Wins++
+				} else {
+					t.Losses++
+				}
+				*list = append(*list, sg.sharedGame)
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	return res, err
+}
+
+func (h *hotsContext) getPlayerSide(
+	ctx context.Context, init initData, region, blizzid string, args map[string]string,
+) (*playerSide, error) {
+	res := &playerSide{
+		Region:      region,
+		Blizzid:     blizzid,
+		Heroes:      make(map[string]Total),
+		Roles:       make(map[string]Total),
+		Percentiles: make(map[string]float64),
+	}
+	wheres := []string{"region = $1", "blizzid = $2"}
+	params := []interface{}{region, blizzid}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	where := strings.Join(wheres, " AND ")
+
+	var err error
+	res.Battletag, err = h.getBattletag(ctx, blizzid, region)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.x.SelectContext(ctx, &res.Skills, `
+		SELECT build, mode, skill
+		FROM playerskills
+		WHERE region = $1 AND blizzid = $2
+		ORDER BY build
+		`, region, blizzid); err != nil {
+		return nil, errors.Wrap(err, "skills")
+	}
+	for i := range res.Skills {
+		res.Skills[i].Build = init.lookups["build"](res.Skills[i].Build)
+	}
+
+	roles := make(map[string]string)
+	for _, h := range init.Heroes {
+		roles[h.Name] = h.Role
+	}
+	var perMinute []string
+	for _, s := range similarityStats {
+		perMinute = append(perMinute, statPerMinute(s))
+	}
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT build, hero, winner, %s
+		FROM players
+		WHERE %s
+		`, strings.Join(perMinute, ", "), where), params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "games")
+	}
+	type buildHero struct {
+		build, hero string
+	}
+	type game struct {
+		buildHero
+		stats []float64
+	}
+	var games []game
+	pairs := make(map[buildHero]bool)
+	for rows.Next() {
+		var g game
+		var winner bool
+		g.stats = make([]float64, len(similarityStats))
+		dest := []interface{}{&g.build, &g.hero, &winner}
+		for i := range g.stats {
+			dest = append(dest, &g.stats[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan")
+		}
+		games = append(games, g)
+		pairs[g.buildHero] = true
+		name := init.lookups["hero"](g.hero)
+		count := func(m map[string]Total, k string) {
+			t := m[k]
+			if winner {
+				t.Wins++
+			} else {
+				t.Losses++
+			}
+			m[k] = t
+		}
+		count(res.Heroes, name)
+		count(res.Roles, roles[name])
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+	if len(games) == 0 {
+		return res, nil
+	}
+
+	// Fetch the distribution of each stat for the builds and heroes the
+	// player played.
+	var tuples []string
+	var pairParams []interface{}
+	for p := range pairs {
+		tuples = append(tuples, makeValues(2, len(pairParams)+1))
+		pairParams = append(pairParams, p.build, p.hero)
+	}
+	var distRows []struct {
+		Build string
+		Hero  string
+		Data  []byte
+	}
+	if err := h.x.SelectContext(ctx, &distRows, fmt.Sprintf(`
+		SELECT build, hero, data
+		FROM herostats
+		WHERE (build, hero) IN (%s)
+		`, strings.Join(tuples, ", ")), pairParams...); err != nil {
+		return nil, errors.Wrap(err, "distributions")
+	}
+	dists := make(map[buildHero]map[string][]float64)
+	for _, row := range distRows {
+		var d map[string][]float64
+		if err := json.Unmarshal(row.Data, &d); err != nil {
+			return nil, errors.Wrap(err, "unmarshal distribution")
+		}
+		dists[buildHero{row.Build, row.Hero}] = d
+	}
+	for i, s := range similarityStats {
+		var sum float64
+		var n int
+		for _, g := range games {
+			q := dists[g.buildHero][s]
+			if len(q) < 2 {
+				continue
+			}
+			sum += percentile(q, g.stats[i])
+			n++
+		}
+		if n > 0 {
+			res.Percentiles[s] = sum / float64(n)
+		}
+	}
+	return res, nil
+}
+
+// percentile returns the fraction of a distribution below v, from its
+// evenly spaced quantiles q. Ties count half.
+func percentile(q []float64, v float64) float64 {
+	lo := sort.SearchFloat64s(q, v)
+	hi := sort.Search(len(q), func(i int) bool { return q[i] > v })
+	var k float64
+	switch {
+	case lo < hi:
+		k = float64(lo+hi-1) / 2
+	case lo == 0:
+		k = 0
+	case lo == len(q):
+		k = float64(len(q) - 1)
+	default:
+		k = float64(lo-1) + (v-q[lo-1])/(q[lo]-q[lo-1])
+	}
+	return k / float64(len(q)-1)
+}
+
+/*
+heroStatDists computes the distribution of each per minute score stat for
+each hero of the newest builds and stores it in the herostats table as
+statQuantiles evenly spaced quantiles per stat. GetPlayerCompare uses them
+to find percentiles without scanning all games of a hero.
+*/
+func (h *hotsContext) heroStatDists() error {
+	ctx := context.Background()
+	if err := h.updateInit(ctx); err != nil {
+		return err
+	}
+	init := h.getInit()
+	var perMinute []string
+	for _, s := range similarityStats {
+		perMinute = append(perMinute, statPerMinute(s))
+	}
+	for _, b := range init.recentBuilds() {
+		start := time.Now()
+		patch := init.config.build(b.ID)
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT hero, %s
+			FROM players
+			WHERE build = $1 AND NOT leaver
+			`, strings.Join(perMinute, ", ")), patch)
+		if err != nil {
+			return errors.Wrap(err, "fetch players")
+		}
+		values := make(map[string][][]float64)
+		for rows.Next() {
+			var hero string
+			stats := make([]float64, len(similarityStats))
+			dest := []interface{}{&hero}
+			for i := range stats {
+				dest = append(dest, &stats[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan")
+			}
+			v := values[hero]
+			if v == nil {
+				v = make([][]float64, len(similarityStats))
+				values[hero] = v
+			}
+			for i, s := range stats {
+				v[i] = append(v[i], s)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "rows")
+		}
+		for hero, v := range values {
+			d := make(map[string][]float64, len(similarityStats))
+			for i, s := range similarityStats {
+				d[s] = quantiles(v[i], statQuantiles)
+			}
+			data, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := retry(func() error {
+				_, err := h.db.Exec(`UPSERT INTO herostats (build, hero, data) VALUES ($1, $2, $3)`, patch, hero, data)
+				return err
+			}); err != nil {
+				return errors.Wrap(err, "upsert herostats")
+			}
+		}
+		fmt.Println("hero stat distributions", b.ID, "took", time.Since(start))
+	}
+	return nil
+}
+
+// quantiles sorts values and returns n+1 evenly spaced quantiles of them,
+// from the minimum to the maximum.
+func quantiles(values []float64, n int) []float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	q := make([]float64, n+1)
+	for i := range q {
+		q[i] = values[i*(len(values)-1)/n]
+	}
+	return q
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// coplayMinGames is the minimum number of games with or against
+	// another player for them to be listed as a friend or opponent.
+	coplayMinGames = 5
+	// coplayLimit is the maximum number of friends or opponents returned.
+	coplayLimit = 40
+	// networkSize is the maximum number of teammates in a player's network.
+	networkSize = 20
+	// coplayBatch is the number of game IDs added to coplay per
+	// transaction. It must divide perFile.
+	coplayBatch = 100
+)
+
+/*
+updateCoplay adds the co-play edges of games with IDs in [start, end). The
+coplay table has, for each directed pair of players in a region, the number
+of games they played together and against each other and how many of those
+the first player won. Games are added in batches of coplayBatch IDs, each in
+its own transaction that also records the batch in coplayapplied. Batches
+already recorded are skipped, so blocks can be re-imported without counting
+their games twice.
+*/
+func (h *hotsContext) updateCoplay(start, end int64) error {
+	for batch := start; batch < end; batch += coplayBatch {
+		batchEnd := batch + coplayBatch
+		if batchEnd > end {
+			batchEnd = end
+		}
+		if err := h.txn(context.Background(), func(txn *sqlx.Tx) error {
+			var applied int
+			if err := txn.Get(&applied, `SELECT count(*) FROM coplayapplied WHERE game = $1`, batch); err != nil {
+				return errors.Wrap(err, "check applied")
+			}
+			if applied > 0 {
+				return nil
+			}
+			if _, err := txn.Exec(`
+				INSERT INTO coplay (region, blizzid, other, together, together_wins, against, against_wins)
+				SELECT
+					p.region,
+					p.blizzid,
+					o.blizzid,
+					sum(CASE WHEN o.team = p.team THEN 1 ELSE 0 END),
+					sum(CASE WHEN o.team = p.team AND p.winner THEN 1 ELSE 0 END),
+					sum(CASE WHEN o.team != p.team THEN 1 ELSE 0 END),
+					sum(CASE WHEN o.team != p.team AND p.winner THEN 1 ELSE 0 END)
+				FROM
+					players AS p
+					JOIN players AS o ON
+						o.game = p.game
+						AND o.region = p.region
+						AND o.blizzid != p.blizzid
+				WHERE p.game >= $1 AND p.game < $2
+				GROUP BY p.region, p.blizzid, o.blizzid
+				ON CONFLICT (region, blizzid, other) DO UPDATE SET
+					together = coplay.together + excluded.together,
+					together_wins = coplay.together_wins + excluded.together_wins,
+					against = coplay.against + excluded.against,
+					against_wins = coplay.against_wins + excluded.against_wins
+				`, batch, batchEnd); err != nil {
+				return errors.Wrap(err, "upsert coplay")
+			}
+			_, err := txn.Exec(`INSERT INTO coplayapplied (game) VALUES ($1)`, batch)
+			return errors.Wrap(err, "mark applied")
+		}); err != nil {
+			return errors.Wrapf(err, "batch %d", batch)
+		}
+	}
+	return nil
+}
+
+// updateAllCoplay runs updateCoplay over all games.
+func (h *hotsContext) updateAllCoplay() error {
+	return h.eachBlock("updating coplay", h.updateCoplay)
+}
+
+type coplayPlayer struct {
+	Battletag string
+	Blizzid   string
+	Games     int
+	// Winrate is the percent of Games won by the requested player.
+	Winrate float64
+}
+
+// getCoplay returns the players most often played with (together is true)
+// or against another player.
+func (h *hotsContext) getCoplay(ctx context.Context, region, blizzid string, together bool) ([]coplayPlayer, error) {
+	games, wins := "against", "against_wins"
+	if together {
+		games, wins = "together", "together_wins"
+	}
+	var res []coplayPlayer
+	if err := h.x.SelectContext(ctx, &res, fmt.Sprintf(`
+		SELECT other AS blizzid, %[1]s AS games, %[2]s::FLOAT / %[1]s::FLOAT * 100 AS winrate
+		FROM coplay
+		WHERE region = $1 AND blizzid = $2 AND %[1]s >= $3
+		ORDER BY %[1]s DESC
+		LIMIT $4
+		`, games, wins), region, blizzid, coplayMinGames, coplayLimit); err != nil {
+		return nil, errors.Wrap(err, games)
+	}
+	ids := make([]string, len(res))
+	for i, p := range res {
+		ids[i] = p.Blizzid
+	}
+	names, err := h.getBattletags(ctx, region, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Battletag = names[res[i].Blizzid]
+	}
+	return res, nil
+}
+
+// anonymizeCoplay replaces the names and removes the blizzids of private
+// players.
+func (h *hotsContext) anonymizeCoplay(ctx context.Context, region string, players []coplayPlayer) error {
+	ids := make([]string, len(players))
+	battletags := make([]*string, len(players))
+	for i := range players {
+		ids[i] = players[i].Blizzid
+		battletags[i] = &players[i].Battletag
+	}
+	private, err := h.anonymize(ctx, region, ids, battletags)
+	if err != nil {
+		return err
+	}
+	for i := range players {
+		if private[i] {
+			players[i].Blizzid = ""
+		}
+	}
+	return nil
+}
+
+// GetPlayerFriends returns the players most often played with and against.
+func (h *hotsContext) GetPlayerFriends(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+
+	res := struct {
+		Battletag string
+		Region    string
+		Friends   []coplayPlayer
+		Opponents []coplayPlayer
+	}{
+		Region: region,
+	}
+	var err error
+	res.Friends, err = h.getCoplay(ctx, region, blizzid, true)
+	if err != nil {
+		return nil, err
+	}
+	res.Opponents, err = h.getCoplay(ctx, region, blizzid, false)
+	if err != nil {
+		return nil, err
+	}
+	res.Battletag, err = h.getBattletag(ctx, blizzid, region)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.anonymizeCoplay(ctx, region, res.Friends); err != nil {
+		return nil, err
+	}
+	if err := h.anonymizeCoplay(ctx, region, res.Opponents); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+type networkEdge struct {
+	// A and B are indexes into Players.
+	A, B    int
+	Games   int
+	Winrate float64
+}
+
+// GetPlayerNetwork returns a player's regular group: their most frequent
+// teammates and how often each pair of them played together. The player
+// is the first entry of Players.
+func (h *hotsContext) GetPlayerNetwork(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	friends, err := h.getCoplay(ctx, region, blizzid, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(friends) > networkSize {
+		friends = friends[:networkSize]
+	}
+	battletag, err := h.getBattletag(ctx, blizzid, region)
+	if err != nil {
+		return nil, err
+	}
+	res := struct {
+		Region  string
+		Players []coplayPlayer
+		Edges   []networkEdge
+	}{
+		Region:  region,
+		Players: []coplayPlayer{{Battletag: battletag, Blizzid: blizzid}},
+	}
+	index := map[string]int{blizzid: 0}
+	params := []interface{}{region}
+	for _, f := range friends {
+		index[f.Blizzid] = len(res.Players)
+		res.Players = append(res.Players, f)
+		params = append(params, f.Blizzid)
+	}
+	if len(friends) == 0 {
+		return res, nil
+	}
+	for i, f := range friends {
+		res.Edges = append(res.Edges, networkEdge{A: 0, B: i + 1, Games: f.Games, Winrate: f.Winrate})
+	}
+
+	var rows []struct {
+		Blizzid string
+		Other   string
+		Games   int
+		Wins    int
+	}
+	values := makeValues(len(friends), 2)
+	if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+		SELECT blizzid, other, together AS games, together_wins AS wins
+		FROM coplay
+		WHERE region = $1 AND blizzid IN %[1]s AND other IN %[1]s AND together >= %[2]d
+		`, values, coplayMinGames), params...); err != nil {
+		return nil, errors.Wrap(err, "network")
+	}
+	for _, row := range rows {
+		a, b := index[row.Blizzid], index[row.Other]
+		// Edges are stored in both directions; keep one.
+		if a > b {
+			continue
+		}
+		res.Edges = append(res.Edges, networkEdge{
+			A:       a,
+			B:       b,
+			Games:   row.Games,
+			Winrate: float64(row.Wins) / float64(row.Games) * 100,
+		})
+	}
+	if err := h.anonymizeCoplay(ctx, region, res.Players); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -46,12 +46,26 @@ func (h *hotsContext) cron() error {
 				res, err = h.GetWinrates(ctx, req)
 			case "/api/get-hero-data":
 				res, err = h.GetRelativeWinrates(ctx, req)
+			case "/api/get-hero-combos":
+				res, err = h.GetHeroCombos(ctx, req)
+			case "/api/get-hero-similarity":
+				res, err = h.GetHeroSimilarity(ctx, req)
+			case "/api/get-hero-timeseries":
+				res, err = h.GetHeroTimeseries(ctx, req)
 			case "/api/get-build-winrates":
 				res, err = h.GetBuildWinrates(ctx, req)
 			case "/api/get-compare-hero":
 				res, err = h.GetCompareHero(ctx, req)
 			case "/api/get-leaderboard":
 				res, err = h.GetLeaderboard(ctx, req)
+			case "/api/get-learning-curves":
+				res, err = h.GetLearningCurves(ctx, req)
+			case "/api/get-map-data":
+				res, err = h.GetMapData(ctx, req)
+			case "/api/get-matchmaking":
+				res, err = h.GetMatchmaking(ctx, req)
+			case "/api/get-win-factors":
+				res, err = h.GetWinFactors(ctx, req)
 			default:
 				log.Printf("cron: unknown path: %s", u)
 				return nil
@@ -101,6 +115,21 @@ func (h *hotsContext) cron() error {
 				}
 			}
 		}
+		// The hero pages above are only forced for all regions. Region
+		// filtered hero pages are refreshed below like any other cached
+		// URL once they have been requested, but the overall winrates are
+		// cheap enough to force per region.
+		v = make(url.Values)
+		v.Add("build", init.Builds[0].ID)
+		u.Path = "/api/get-winrates"
+		for r := range init.Regions {
+			v.Set("region", fmt.Sprint(r))
+			u.RawQuery = v.Encode()
+			log.Printf("force update: %s", u.String())
+			if err := update(u.String(), true); err != nil {
+				return errors.Wrap(err, u.String())
+			}
+		}
 		v = make(url.Values)
 		u.Path = "/api/get-leaderboard"
 		for m := range init.Modes {
@@ -269,6 +269,42 @@ func (h *hotsContext) Import(bucket string, max int) error {
 		args...); err != nil {
 		return errors.Wrap(err, "import games")
 	}
+	if _, err := h.db.Exec(`
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS leaver BOOL NOT NULL DEFAULT false;
+		ALTER TABLE players ADD COLUMN IF NOT EXISTS leaver BOOL NOT NULL DEFAULT false;
+		ALTER TABLE players ADD COLUMN IF NOT EXISTS hero_games INT;
+		ALTER TABLE players ADD COLUMN IF NOT EXISTS skill_sigma FLOAT;
+	`); err != nil {
+		return errors.Wrap(err, "add columns")
+	}
+	if err := h.markAllLeavers(); err != nil {
+		return err
+	}
+	if err := h.countAllHeroGames(); err != nil {
+		return err
+	}
+	if err := h.updateAllBattletags(); err != nil {
+		return err
+	}
+	return h.updateAllCoplay()
+}
+
+// eachBlock runs f over all games in blocks of perFile game IDs, logging
+// name at each block. It does nothing if there are no games.
+func (h *hotsContext) eachBlock(name string, f func(start, end int64) error) error {
+	var min, max sql.NullInt64
+	if err := h.db.QueryRow(`SELECT min(id), max(id) FROM games`).Scan(&min, &max); err != nil {
+		return errors.Wrap(err, "game ids")
+	}
+	if !min.Valid {
+		return nil
+	}
+	for start := min.Int64 - min.Int64%perFile; start <= max.Int64; start += perFile {
+		fmt.Println(name, start)
+		if err := f(start, start+perFile); err != nil {
+			return errors.Wrapf(err, "%s: %d", name, start)
+		}
+	}
 	return nil
 }
 
@@ -34,14 +34,38 @@ type Update struct {
 	score   skills.Rating
 }
 
+// League is a named skill bracket.
+type League struct {
+	Name string
+	// Quantile is the percentile of a skill distribution at which the
+	// league begins.
+	Quantile int
+}
+
+// leagueConfig configures the skill brackets. It is stored as JSON in the
+// config table under configLeagues. Changing the quantiles requires
+// rerunning elo to compute them.
+type leagueConfig struct {
+	// Leagues are ordered from lowest to highest.
+	Leagues []League
+	// Grandmaster is the number of top leaderboard players placed above the
+	// highest league.
+	Grandmaster int
+}
+
+const configLeagues = "leagues"
+
 // https://www.reddit.com/r/heroesofthestorm/comments/82p1h5/ranked_player_distribution/dvbzypz/
-var skillQuantiles = []int{
-	0,
-	7,
-	42,
-	77,
-	92,
-	99,
+var defaultLeagues = leagueConfig{
+	Leagues: []League{
+		{"Bronze", 0},
+		{"Silver", 7},
+		{"Gold", 42},
+		{"Platinum", 77},
+		{"Diamond", 92},
+		{"Master", 99},
+	},
+	Grandmaster: 50,
 }
 
 /*
@@ -61,8 +85,10 @@ func (h *hotsContext) elo() error {
 		return err
 	}
 	init := h.getInit()
-	// Hard code to use the last 3 versions. This is bad but fine for now.
-	updateAfterPatch := init.Builds[2].ID
+	recent := make(map[string]bool)
+	for _, b := range init.recentBuilds() {
+		recent[b.ID] = true
+	}
 
 	config, err := pgx.ParseURI(h.dburl)
 	if err != nil {
@@ -79,7 +105,7 @@ func (h *hotsContext) elo() error {
 	defer pool.Close()
 
 	const stmtUpdatePlayers = "updatePlayers"
-	if _, err := pool.Prepare(stmtUpdatePlayers, "update players set skill = $1 where game = $2 and blizzid = $3"); err != nil {
+	if _, err := pool.Prepare(stmtUpdatePlayers, "update players set skill = $1, skill_sigma = $2 where game = $3 and blizzid = $4"); err != nil {
 		return errors.Wrap(err, "make update players")
 	}
 	const stmtUpdateSkills = "updateSkills"
@@ -99,7 +125,7 @@ func (h *hotsContext) elo() error {
 
 	for i := len(init.Builds) - 1; i >= 0; i-- {
 		build := init.Builds[i]
-		updatePatch := build.ID >= updateAfterPatch || *flagInit
+		updatePatch := recent[build.ID] || *flagInit
 		start := time.Now()
 		patch := init.config.build(build.ID)
 		isRecent := build.Start.After(since)
@@ -141,7 +167,7 @@ func (h *hotsContext) elo() error {
 				defer close(gameCh)
 
 				// fetch games in order
-				games, err := pool.QueryEx(gCtx, "select id, mode, region from games where build = $1 order by time", nil, patch)
+				games, err := pool.QueryEx(gCtx, "select id, mode, region from games where build = $1 and not leaver order by time", nil, patch)
 				if err != nil {
 					return errors.Wrap(err, "fetch games in order")
 				}
@@ -228,7 +254,7 @@ func (h *hotsContext) elo() error {
 			for i := 0; i < poolConfig.MaxConnections; i++ {
 				g.Go(func() error {
 					for u := range updateCh {
-						args := []interface{}{ratingToSkill(u.score), u.game, u.blizzid}
+						args := []interface{}{ratingToSkill(u.score), u.score.Stddev(), u.game, u.blizzid}
 						if _, err := pool.ExecEx(gCtx, stmtUpdatePlayers, nil, args...); err != nil {
 							return errors.Wrapf(err, "stmtUpdatePlayers: %v", args)
 						}
@@ -292,27 +318,27 @@ func (h *hotsContext) elo() error {
 			}
 			fmt.Println("getting stats", time.Since(start))
 			for m, blizzids := range scores {
-				// For each mode, get all the scores and sort them.
+				// For each mode, get all the scores and sort them, overall and
+				// per region.
 				const buckets = 50
 				hist := gohistogram.NewHistogram(buckets)
-				for _, sc := range blizzids {
-					if sc.patch == patch {
-						hist.Add(sc.score.Mean())
+				regionHists := make(map[int]*gohistogram.NumericHistogram)
+				for rp, sc := range blizzids {
+					if sc.patch != patch {
+						continue
 					}
+					hist.Add(sc.score.Mean())
+					rh := regionHists[rp.region]
+					if rh == nil {
+						rh = gohistogram.NewHistogram(buckets)
+						regionHists[rp.region] = rh
+					}
+					rh.Add(sc.score.Mean())
 				}
 				if hist.Count() == 0 {
 					continue
 				}
-				s := Stats{
-					Count:    int(hist.Count()),
-					Mean:     hist.Mean(),
-					StdDev:   math.Sqrt(hist.Variance()),
-					Quantile: make(map[int]float64),
-				}
-				for _, q := range skillQuantiles {
-					s.Quantile[q] = hist.Quantile(float64(q) / 100)
-				}
-				b, err := json.Marshal(s)
+				b, err := json.Marshal(histStats(hist, init.Leagues))
 				if err != nil {
 					return err
 				}
@@ -321,11 +347,29 @@ func (h *hotsContext) elo() error {
 				); err != nil {
 					return err
 				}
+				for r, rh := range regionHists {
+					b, err := json.Marshal(histStats(rh, init.Leagues))
+					if err != nil {
+						return err
+					}
+					if _, err := pool.Exec("upsert into regionskillstats (build, region, mode, data) values ($1, $2, $3, $4)",
+						patch, r, m, b,
+					); err != nil {
+						return err
+					}
+				}
 			}
 		}
 		fmt.Println(build.ID, "took", time.Since(start))
 	}
-	fmt.Println("begin leaderboard")
+	if err := h.updateSuspicion(context.Background()); err != nil {
+		return errors.Wrap(err, "suspicion")
+	}
+	excluded, err := h.suspicionExcluded(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Println("begin leaderboard, excluding", len(excluded), "suspicious accounts")
 	// Calculate leaderboard
 	type leaderboardSkill struct {
 		blizzid int64
@@ -340,7 +384,7 @@ func (h *hotsContext) elo() error {
 			skills = skills[:0]
 			for rp, ps := range scores[m] {
 				tg := totalGames[modeRegion{m, rp}]
-				if rp.region != r || tg.recent < leaderboardMinGames {
+				if rp.region != r || tg.recent < leaderboardMinGames || excluded[rp] {
 					continue
 				}
 				skills = append(skills, leaderboardSkill{
@@ -404,6 +448,29 @@ type modeRegion struct {
 	rp   regionPlayer
 }
 
+// recentVersions is the number of newest versions whose builds are
+// recomputed by elo and the offline analyses. Older builds rarely get new
+// games. This is hard coded, which is bad but fine for now.
+const recentVersions = 3
+
+// recentBuilds returns the builds of the newest recentVersions versions,
+// newest first. Hotfix segments are part of their version. Elo and the
+// offline analyses (win factors, combos, similarity, stat distributions and
+// suspicion) only recompute these builds.
+func (i initData) recentBuilds() []Build {
+	var res []Build
+	versions := make(map[string]bool)
+	for _, b := range i.Builds {
+		v := buildVersion(b.ID)
+		if !versions[v] && len(versions) == recentVersions {
+			break
+		}
+		versions[v] = true
+		res = append(res, b)
+	}
+	return res
+}
+
 type regionPlayer struct {
 	region  int
 	blizzid int64
@@ -436,3 +503,17 @@ type Stats struct {
 	StdDev   float64
 	Quantile map[int]float64
 }
+
+// histStats returns the stats of hist at the quantiles of leagues.
+func histStats(hist *gohistogram.NumericHistogram, leagues []League) Stats {
+	s := Stats{
+		Count:    int(hist.Count()),
+		Mean:     hist.Mean(),
+		StdDev:   math.Sqrt(hist.Variance()),
+		Quantile: make(map[int]float64),
+	}
+	for _, l := range leagues {
+		s.Quantile[l.Quantile] = hist.Quantile(float64(l.Quantile) / 100)
+	}
+	return s
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// exportGame is a game of an exported player with everyone in it.
+type exportGame struct {
+	playerGame
+	Talents   []string
+	Data      json.RawMessage
+	Teammates []*gamePlayer
+	Opponents []*gamePlayer
+}
+
+type playerExport struct {
+	Region     string
+	Blizzid    string
+	Exported   time.Time
+	Battletags []battletagHistory
+	Games      []exportGame
+	Skill      map[Mode]*skillTimeline
+}
+
+/*
+ExportPlayer writes a zip archive of everything stored about a player
+(region and blizzid): all games with teammates, opponents, talents and score
+data, the skill timeline, and battletag history. The archive has the export
+as export.json and the same data as CSV files. Private players are not
+exported, and private players in their games are anonymized.
+*/
+func (h *hotsContext) ExportPlayer(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute*5)
+	defer cancel()
+	exp, err := h.getPlayerExport(ctx, r)
+	if err != nil {
+		log.Printf("%s: %+v", r.URL, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exp == nil {
+		http.Error(w, "player is private", http.StatusForbidden)
+		return
+	}
+	w.Header().Add("Content-Type", "application/zip")
+	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="hots-%s-%s.zip"`, exp.Region, exp.Blizzid))
+	// Headers are sent, so errors can only be logged.
+	if err := writePlayerExport(w, exp); err != nil {
+		log.Printf("%s: %+v", r.URL, err)
+	}
+}
+
+func (h *hotsContext) getPlayerExport(ctx context.Context, r *http.Request) (*playerExport, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	private, err := h.isPrivate(ctx, region, blizzid)
+	if err != nil || private {
+		return nil, err
+	}
+	init := h.getInit()
+	exp := &playerExport{
+		Region:   region,
+		Blizzid:  blizzid,
+		Exported: time.Now().UTC(),
+	}
+	exp.Battletags, err = h.getBattletagHistory(ctx, blizzid, region)
+	if err != nil {
+		return nil, err
+	}
+
+	// Page through all games.
+	req := playerRequest(&http.Request{}, region, blizzid)
+	form := req.URL.Query()
+	form.Set("limit", strconv.Itoa(maxPlayerGamesLimit))
+	for {
+		req.URL.RawQuery = form.Encode()
+		req.Form = nil
+		page, err := h.GetPlayerGames(ctx, req)
+		if err != nil {
+			return nil, errors.Wrap(err, "games")
+		}
+		games := page.(playerGames)
+		for _, g := range games.Games {
+			exp.Games = append(exp.Games, exportGame{playerGame: g})
+		}
+		if games.Next == "" {
+			break
+		}
+		form.Set("cursor", games.Next)
+	}
+
+	players, err := h.getGamePlayers(ctx, init, `game IN (
+		SELECT game
+		FROM players
+		WHERE region = $1 AND blizzid = $2
+	)`, region, blizzid)
+	if err != nil {
+		return nil, errors.Wrap(err, "game players")
+	}
+	byGame := make(map[int64][]*gamePlayer)
+	for _, p := range players {
+		byGame[p.Game] = append(byGame[p.Game], p)
+	}
+	isPlayer := func(p *gamePlayer) bool {
+		return strconv.Itoa(p.Region) == region && strconv.Itoa(p.Blizzid) == blizzid
+	}
+	for i := range exp.Games {
+		g := &exp.Games[i]
+		team := -1
+		for _, p := range byGame[int64(g.Game)] {
+			if isPlayer(p) {
+				team = p.Team
+				g.Talents = p.TalentList
+				g.Data = p.Data
+			}
+		}
+		for _, p := range byGame[int64(g.Game)] {
+			if isPlayer(p) {
+				continue
+			}
+			if p.Team == team {
+				g.Teammates = append(g.Teammates, p)
+			} else {
+				g.Opponents = append(g.Opponents, p)
+			}
+		}
+	}
+
+	skill, err := h.GetPlayerSkillTimeline(ctx, playerRequest(&http.Request{}, region, blizzid))
+	if err != nil {
+		return nil, errors.Wrap(err, "skill")
+	}
+	exp.Skill = skill.(map[Mode]*skillTimeline)
+	return exp, nil
+}
+
+func writePlayerExport(w io.Writer, exp *playerExport) error {
+	z := zip.NewWriter(w)
+	{
+		f, err := z.Create("export.json")
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(exp); err != nil {
+			return errors.Wrap(err, "json")
+		}
+	}
+	writeCSV := func(name string, header []string, rows [][]string) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		c := csv.NewWriter(f)
+		if err := c.Write(header); err != nil {
+			return err
+		}
+		if err := c.WriteAll(rows); err != nil {
+			return errors.Wrap(err, name)
+		}
+		return nil
+	}
+	itoa := strconv.Itoa
+	btoa := strconv.FormatBool
+	ftoa := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+
+	var games, players [][]string
+	for _, g := range exp.Games {
+		games = append(games, []string{
+			itoa(g.Game), g.Date, g.Build, itoa(int(g.Mode)), g.Map, itoa(g.Length),
+			g.Hero, itoa(g.HeroLevel), btoa(g.Winner), ftoa(g.Skill),
+			itoa(g.Kills), itoa(g.Deaths), itoa(g.Assists),
+			itoa(g.HeroDamage), itoa(g.SiegeDamage), itoa(g.Healing),
+			itoa(g.DamageTaken), itoa(g.ExperienceContribution),
+			strings.Join(g.Talents, " "), string(g.Data),
+		})
+		for _, side := range []struct {
+			team    string
+			players []*gamePlayer
+		}{
+			{"teammate", g.Teammates},
+			{"opponent", g.Opponents},
+		} {
+			for _, p := range side.players {
+				players = append(players, []string{
+					itoa(g.Game), side.team, itoa(p.Region), itoa(p.Blizzid), p.Battletag,
+					p.Hero, itoa(p.HeroLevel), btoa(p.Winner),
+					strings.Join(p.TalentList, " "), string(p.Data),
+				})
+			}
+		}
+	}
+	if err := writeCSV("games.csv", []string{
+		"game", "time", "build", "mode", "map", "length",
+		"hero", "hero_level", "winner", "skill",
+		"kills", "deaths", "assists",
+		"hero_damage", "siege_damage", "healing",
+		"damage_taken", "experience_contribution",
+		"talents", "data",
+	}, games); err != nil {
+		return err
+	}
+	if err := writeCSV("players.csv", []string{
+		"game", "side", "region", "blizzid", "battletag",
+		"hero", "hero_level", "winner", "talents", "data",
+	}, players); err != nil {
+		return err
+	}
+
+	var modes []int
+	for m := range exp.Skill {
+		modes = append(modes, int(m))
+	}
+	sort.Ints(modes)
+	var skill [][]string
+	for _, mode := range modes {
+		for _, p := range exp.Skill[Mode(mode)].Points {
+			skill = append(skill, []string{
+				itoa(mode), strconv.FormatInt(p.Game, 10), p.Time.UTC().Format(time.RFC3339),
+				p.Hero, btoa(p.Winner), ftoa(p.Skill), ftoa(p.SkillSigma), ftoa(p.Change),
+			})
+		}
+	}
+	if err := writeCSV("skill.csv", []string{
+		"mode", "game", "time", "hero", "winner", "skill", "skill_sigma", "change",
+	}, skill); err != nil {
+		return err
+	}
+
+	var names [][]string
+	for _, b := range exp.Battletags {
+		names = append(names, []string{
+			b.Battletag, b.FirstSeen.UTC().Format(time.RFC3339), b.LastSeen.UTC().Format(time.RFC3339),
+		})
+	}
+	if err := writeCSV("battletags.csv", []string{"battletag", "first_seen", "last_seen"}, names); err != nil {
+		return err
+	}
+	return errors.Wrap(z.Close(), "zip")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisHines/GoSkills/skills"
+	"github.com/pkg/errors"
+)
+
+const (
+	// fairnessBucket is the width of the skill gap buckets.
+	fairnessBucket = 1.0
+	// fairnessMaxBucket is the lower bound of the last skill gap bucket.
+	fairnessMaxBucket = 10.0
+	// stompLength is the game length in seconds under which a game is a
+	// stomp.
+	stompLength = 12 * 60
+)
+
+type gapSummary struct {
+	Games int
+	// MeanGap and MedianGap are team average skill differences.
+	MeanGap   float64
+	MedianGap float64
+	// Buckets are game counts keyed by the lower bound of the gap.
+	Buckets map[string]int
+}
+
+type gapOutcome struct {
+	Gap   float64
+	Games int
+	// Predicted is the average TrueSkill win probability of the favored
+	// team, Actual how often it won.
+	Predicted float64
+	Actual    float64
+	Stomps    int
+	StompRate float64
+}
+
+type fairnessGame struct {
+	mode   Mode
+	region int
+	hour   int
+	length int
+	skill  [2]float64
+	// variance is the sum of the players' rating variances.
+	variance [2]float64
+	count    [2]int
+	winner   [2]bool
+}
+
+// gap returns the absolute team average skill difference and whether team 0
+// is favored.
+func (g *fairnessGame) gap() (float64, bool) {
+	d := g.skill[0]/float64(g.count[0]) - g.skill[1]/float64(g.count[1])
+	return math.Abs(d), d >= 0
+}
+
+// predicted returns the TrueSkill win probability of the favored team
+// from the players' rating means and variances and the performance
+// variance (beta).
+func (g *fairnessGame) predicted() float64 {
+	d := math.Abs(g.skill[0] - g.skill[1])
+	beta := skills.DefaultGameInfo.Beta
+	n := float64(g.count[0] + g.count[1])
+	variance := g.variance[0] + g.variance[1] + n*beta*beta
+	return 0.5 * (1 + math.Erf(d/math.Sqrt(variance)/math.Sqrt2))
+}
+
+func gapBucket(gap float64) float64 {
+	return math.Min(math.Floor(gap/fairnessBucket)*fairnessBucket, fairnessMaxBucket)
+}
+
+func summarizeGaps(gaps []float64) gapSummary {
+	s := gapSummary{
+		Games:   len(gaps),
+		Buckets: make(map[string]int),
+	}
+	if len(gaps) == 0 {
+		return s
+	}
+	sort.Float64s(gaps)
+	var sum float64
+	for _, g := range gaps {
+		sum += g
+		s.Buckets[strconv.FormatFloat(gapBucket(g), 'f', -1, 64)]++
+	}
+	s.MeanGap = sum / float64(len(gaps))
+	s.MedianGap = gaps[len(gaps)/2]
+	return s
+}
+
+// GetMatchmaking returns how far apart in skill teams were, and how well
+// the skill difference predicted the winner and stomps.
+func (h *hotsContext) GetMatchmaking(ctx context.Context, r *http.Request) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
+	init := h.getInit()
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
+	}
+	// Unrated games have a skill of 0.
+	wheres = append(wheres, "skill > 0")
+	initial := skills.DefaultGameInfo.DefaultRating()
+	params = append(params, initial.Mean(), initial.Variance())
+	// The stored skill of a game is the rating after it. Matchmaking saw
+	// the rating before it, which is the skill of the player's previous
+	// rated game in the mode, or the default rating if there was none.
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			game,
+			mode,
+			region,
+			extract(hour FROM time)::INT,
+			length,
+			team,
+			bool_or(winner),
+			sum(COALESCE(pre, $%[2]d)),
+			sum(COALESCE(pre_sigma * pre_sigma, $%[3]d)),
+			count(*)
+		FROM
+			(
+				SELECT
+					game, mode, region, time, length, team, winner,
+					lag(skill) OVER (PARTITION BY region, blizzid, mode ORDER BY time, game) AS pre,
+					lag(skill_sigma) OVER (PARTITION BY region, blizzid, mode ORDER BY time, game) AS pre_sigma
+				FROM players
+				WHERE
+					skill > 0
+					AND (region, blizzid) IN (SELECT region, blizzid FROM players WHERE %[1]s)
+			)
+		WHERE game IN (SELECT game FROM players WHERE %[1]s)
+		GROUP BY game, mode, region, time, length, team
+		`, strings.Join(wheres, " AND "), len(params)-1, len(params)), params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch teams")
+	}
+	games := make(map[int64]*fairnessGame)
+	for rows.Next() {
+		var id int64
+		var fg fairnessGame
+		var team, count int
+		var winner bool
+		var skill, variance float64
+		if err := rows.Scan(&id, &fg.mode, &fg.region, &fg.hour, &fg.length, &team, &winner, &skill, &variance, &count); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan")
+		}
+		if team != 0 && team != 1 {
+			continue
+		}
+		g := games[id]
+		if g == nil {
+			g = &fg
+			games[id] = g
+		}
+		g.skill[team] = skill
+		g.variance[team] = variance
+		g.count[team] = count
+		g.winner[team] = winner
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+
+	var res struct {
+		All      gapSummary
+		ByMode   map[string]gapSummary
+		ByRegion map[string]gapSummary
+		// ByHour is keyed by the UTC hour the game was played.
+		ByHour   map[string]gapSummary
+		Outcomes []gapOutcome
+	}
+	var all []float64
+	byMode := make(map[string][]float64)
+	byRegion := make(map[string][]float64)
+	byHour := make(map[string][]float64)
+	outcomes := make(map[float64]*gapOutcome)
+	for _, g := range games {
+		// Skip games missing a team or without a single winner.
+		if g.count[0] == 0 || g.count[1] == 0 || g.winner[0] == g.winner[1] {
+			continue
+		}
+		gap, team0 := g.gap()
+		all = append(all, gap)
+		mode := strconv.Itoa(int(g.mode))
+		byMode[mode] = append(byMode[mode], gap)
+		region := strconv.Itoa(g.region)
+		byRegion[region] = append(byRegion[region], gap)
+		hour := strconv.Itoa(g.hour)
+		byHour[hour] = append(byHour[hour], gap)
+
+		b := gapBucket(gap)
+		o := outcomes[b]
+		if o == nil {
+			o = &gapOutcome{Gap: b}
+			outcomes[b] = o
+		}
+		o.Games++
+		o.Predicted += g.predicted()
+		if g.winner[0] == team0 {
+			o.Actual++
+		}
+		if g.length < stompLength {
+			o.Stomps++
+		}
+	}
+	res.All = summarizeGaps(all)
+	res.ByMode = make(map[string]gapSummary)
+	for k, v := range byMode {
+		res.ByMode[k] = summarizeGaps(v)
+	}
+	res.ByRegion = make(map[string]gapSummary)
+	for k, v := range byRegion {
+		res.ByRegion[k] = summarizeGaps(v)
+	}
+	res.ByHour = make(map[string]gapSummary)
+	for k, v := range byHour {
+		res.ByHour[k] = summarizeGaps(v)
+	}
+	for _, o := range outcomes {
+		n := float64(o.Games)
+		o.Predicted /= n
+		o.Actual /= n
+		o.StompRate = float64(o.Stomps) / n
+		res.Outcomes = append(res.Outcomes, *o)
+	}
+	sort.Slice(res.Outcomes, func(i, j int) bool {
+		return res.Outcomes[i].Gap < res.Outcomes[j].Gap
+	})
+	return res, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// heroGamesBuckets are the lower bounds of the groups of hero_games, the
+// number of games a player had played on a hero before a game.
+var heroGamesBuckets = []int{0, 5, 10, 25, 50, 100, 250}
+
+// learningCurveMinGames is the minimum number of games in a bucket for it to
+// be used when computing the winrate gain of a learning curve.
+const learningCurveMinGames = 100
+
+// heroGamesCase returns a SQL expression grouping hero_games into
+// heroGamesBuckets.
+func heroGamesCase() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	for i := len(heroGamesBuckets) - 1; i > 0; i-- {
+		fmt.Fprintf(&sb, " WHEN hero_games >= %d THEN %d", heroGamesBuckets[i], heroGamesBuckets[i])
+	}
+	fmt.Fprintf(&sb, " ELSE %d END", heroGamesBuckets[0])
+	return sb.String()
+}
+
+/*
+countHeroGames sets hero_games of the players of games with IDs in
+[start, end) to the number of games they played on the same hero before,
+ordered by time. Counts are based on the games in the database at the time,
+so games imported later with an earlier time are not counted.
+*/
+func (h *hotsContext) countHeroGames(start, end int64) error {
+	var rows []struct {
+		Game    int64
+		Blizzid int64
+		N       int64
+	}
+	if err := h.x.Select(&rows, `
+		SELECT game, blizzid, n
+		FROM (
+			SELECT
+				game,
+				blizzid,
+				row_number() OVER (PARTITION BY region, blizzid, hero ORDER BY time, game) - 1 AS n
+			FROM players
+			WHERE (region, blizzid) IN (
+				SELECT region, blizzid
+				FROM players
+				WHERE game >= $1 AND game < $2
+			)
+		)
+		WHERE game >= $1 AND game < $2
+		`, start, end); err != nil {
+		return errors.Wrap(err, "count hero games")
+	}
+	const batch = 1000
+	for len(rows) > 0 {
+		n := batch
+		if n > len(rows) {
+			n = len(rows)
+		}
+		var buf bytes.Buffer
+		buf.WriteString("UPSERT INTO players (game, blizzid, hero_games) VALUES ")
+		params := make([]interface{}, 0, n*3)
+		for i, r := range rows[:n] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(makeValues(3, i*3+1))
+			params = append(params, r.Game, r.Blizzid, r.N)
+		}
+		if err := retry(func() error {
+			_, err := h.db.Exec(buf.String(), params...)
+			return err
+		}); err != nil {
+			return errors.Wrap(err, "update hero games")
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// countAllHeroGames runs countHeroGames over all games.
+func (h *hotsContext) countAllHeroGames() error {
+	return h.eachBlock("counting hero games", h.countHeroGames)
+}
+
+type learningCurve struct {
+	// Buckets are winrates keyed by the lower bound of the number of
+	// games played on the hero before.
+	Buckets map[string]Total
+	// Gain is the winrate of the most experienced bucket minus that of
+	// the least experienced one, using only buckets with enough games.
+	Gain float64
+}
+
+// GetLearningCurves returns hero winrates grouped by the number of games
+// the player had played on the hero before.
+func (h *hotsContext) GetLearningCurves(ctx context.Context, r *http.Request) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
+	init := h.getInit()
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
+	}
+	if err := setSkillParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	wheres = append(wheres, "hero_games IS NOT NULL")
+	var rows []struct {
+		Hero    string
+		Counter int
+		Winner  bool
+		Count   int
+	}
+	if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+		SELECT hero, counter, winner, count(*) AS count
+		FROM (
+			SELECT hero, winner, %s AS counter
+			FROM players
+			WHERE %s
+		)
+		GROUP BY hero, counter, winner
+		`, heroGamesCase(), strings.Join(wheres, " AND ")), params...); err != nil {
+		return nil, errors.Wrap(err, "learning curves")
+	}
+	res := make(map[string]*learningCurve)
+	for _, r := range rows {
+		name := init.lookups["hero"](r.Hero)
+		c := res[name]
+		if c == nil {
+			c = &learningCurve{Buckets: make(map[string]Total)}
+			res[name] = c
+		}
+		k := strconv.Itoa(r.Counter)
+		t := c.Buckets[k]
+		if r.Winner {
+			t.Wins += r.Count
+		} else {
+			t.Losses += r.Count
+		}
+		c.Buckets[k] = t
+	}
+	for _, c := range res {
+		var winrates []float64
+		for _, b := range heroGamesBuckets {
+			t := c.Buckets[strconv.Itoa(b)]
+			if n := t.Wins + t.Losses; n >= learningCurveMinGames {
+				winrates = append(winrates, float64(t.Wins)/float64(n))
+			}
+		}
+		if len(winrates) > 1 {
+			c.Gain = winrates[len(winrates)-1] - winrates[0]
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// A player is probably a leaver or AFK if, per minute of a game of at least
+// leaverMinLength seconds, they had less than both leaverXP experience
+// contribution and leaverActivity hero damage plus healing.
+const (
+	leaverMinLength = 5 * 60
+	leaverXP        = 60
+	leaverActivity  = 100
+)
+
+// markLeavers sets the leaver flag of games with IDs in [start, end) that
+// probably had a leaver or AFK player. Players without experience
+// contribution or hero damage data are never counted as leavers.
+func (h *hotsContext) markLeavers(start, end int64) error {
+	return h.txn(context.Background(), func(txn *sqlx.Tx) error {
+		var games []int64
+		if err := txn.Select(&games, `
+			SELECT DISTINCT game
+			FROM players
+			WHERE
+				game >= $1 AND game < $2
+				AND length >= $3
+				-- Games without score data can't be judged.
+				AND data ? 'experience_contribution'
+				AND data ? 'hero_damage'
+				AND (data->>'experience_contribution')::FLOAT * 60 / length::FLOAT < $4
+				AND (
+					(data->>'hero_damage')::FLOAT
+					+ COALESCE((data->>'healing')::FLOAT, 0)
+				) * 60 / length::FLOAT < $5
+			`, start, end, leaverMinLength, leaverXP, leaverActivity); err != nil {
+			return errors.Wrap(err, "find leavers")
+		}
+		if len(games) == 0 {
+			return nil
+		}
+		ids := make([]interface{}, len(games))
+		for i, g := range games {
+			ids[i] = g
+		}
+		if _, err := txn.Exec(fmt.Sprintf(
+			`UPDATE games SET leaver = true WHERE id IN %s`, makeValues(len(ids), 1),
+		), ids...); err != nil {
+			return errors.Wrap(err, "update games")
+		}
+		if _, err := txn.Exec(fmt.Sprintf(
+			`UPDATE players SET leaver = true WHERE game IN %s`, makeValues(len(ids), 1),
+		), ids...); err != nil {
+			return errors.Wrap(err, "update players")
+		}
+		return nil
+	})
+}
+
+// markAllLeavers runs markLeavers over all games.
+func (h *hotsContext) markAllLeavers() error {
+	return h.eachBlock("marking leavers", h.markLeavers)
+}
+
+// setLeaverParams excludes games with a probable leaver if the
+// exclude_leavers argument is true.
+func setLeaverParams(wheres *[]string, args map[string]string) error {
+	v := args["exclude_leavers"]
+	if v == "" {
+		return nil
+	}
+	exclude, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.Wrap(err, "parse exclude_leavers")
+	}
+	if exclude {
+		*wheres = append(*wheres, "NOT leaver")
+	}
+	return nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// linkedAccount is a player in one region.
+type linkedAccount struct {
+	Region    string
+	Blizzid   string
+	Battletag string
+}
+
+// getLinkedAccounts returns the confirmed accounts linked to a player,
+// including the player, ordered by region. A player with no confirmed links
+// is returned alone.
+func (h *hotsContext) getLinkedAccounts(ctx context.Context, region, blizzid string) ([]linkedAccount, error) {
+	var res []linkedAccount
+	if err := h.x.SelectContext(ctx, &res, `
+		SELECT region, blizzid
+		FROM accountlinks
+		WHERE confirmed AND account = (
+			SELECT account
+			FROM accountlinks
+			WHERE region = $1 AND blizzid = $2 AND confirmed
+		)
+		ORDER BY region
+		`, region, blizzid); err != nil {
+		return nil, errors.Wrap(err, "linked accounts")
+	}
+	if len(res) < 2 {
+		res = []linkedAccount{{Region: region, Blizzid: blizzid}}
+	}
+	return h.namedAccounts(ctx, res)
+}
+
+// namedAccounts removes private players from accounts and sets the
+// battletags of the rest.
+func (h *hotsContext) namedAccounts(ctx context.Context, accounts []linkedAccount) ([]linkedAccount, error) {
+	res, err := h.withoutPrivate(ctx, accounts)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range res {
+		var err error
+		res[i].Battletag, err = h.getBattletag(ctx, a.Blizzid, a.Region)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+type accountLinks struct {
+	Linked []linkedAccount
+	// Pending are players in the same account that are not linked until
+	// both they and the requested player are confirmed.
+	Pending     []linkedAccount
+	Suggestions []linkedAccount
+}
+
+// GetAccountLinks returns the accounts linked to a player, pending links,
+// and suggested accounts to link: players in other regions that have had an
+// identical battletag, including the discriminator. Suggestions are only
+// linked once requested with LinkAccounts and confirmed by each player with
+// ConfirmAccountLink.
+func (h *hotsContext) GetAccountLinks(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	return h.getAccountLinks(ctx, region, blizzid)
+}
+
+func (h *hotsContext) getAccountLinks(ctx context.Context, region, blizzid string) (*accountLinks, error) {
+	res := new(accountLinks)
+	var err error
+	res.Linked, err = h.getLinkedAccounts(ctx, region, blizzid)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]bool)
+	for _, a := range res.Linked {
+		linked[a.Region] = true
+	}
+	var account []linkedAccount
+	if err := h.x.SelectContext(ctx, &account, `
+		SELECT region, blizzid
+		FROM accountlinks
+		WHERE account = (
+			SELECT account
+			FROM accountlinks
+			WHERE region = $1 AND blizzid = $2
+		)
+		ORDER BY region
+		`, region, blizzid); err != nil {
+		return nil, errors.Wrap(err, "account")
+	}
+	var pending []linkedAccount
+	for _, a := range account {
+		if !linked[a.Region] && a.Region != region {
+			pending = append(pending, a)
+		}
+	}
+	res.Pending, err = h.namedAccounts(ctx, pending)
+	if err != nil {
+		return nil, err
+	}
+	// A player can only be in one account per region.
+	taken := map[string]bool{region: true}
+	for _, a := range account {
+		taken[a.Region] = true
+	}
+	var suggestions []linkedAccount
+	// The battletag column is case insensitive, so compare again as
+	// strings to only suggest identical names.
+	if err := h.x.SelectContext(ctx, &suggestions, `
+		SELECT DISTINCT o.region, o.blizzid, o.battletag::STRING AS battletag
+		FROM
+			battletags AS p
+			JOIN battletags AS o ON
+				o.battletag = p.battletag
+				AND o.battletag::STRING = p.battletag::STRING
+				AND o.region != p.region
+		WHERE p.region = $1 AND p.blizzid = $2
+		ORDER BY o.region, o.blizzid
+		`, region, blizzid); err != nil {
+		return nil, errors.Wrap(err, "suggestions")
+	}
+	suggestions, err = h.withoutPrivate(ctx, suggestions)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range suggestions {
+		if !taken[s.Region] {
+			res.Suggestions = append(res.Suggestions, s)
+		}
+	}
+	return res, nil
+}
+
+// withoutPrivate removes private players from accounts.
+func (h *hotsContext) withoutPrivate(ctx context.Context, accounts []linkedAccount) ([]linkedAccount, error) {
+	players := make([]regionPlayer, len(accounts))
+	for i, a := range accounts {
+		var err error
+		players[i], err = parseRegionPlayer(a.Region, a.Blizzid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	private, err := h.getPseudonyms(ctx, players)
+	if err != nil {
+		return nil, err
+	}
+	var res []linkedAccount
+	for i, a := range accounts {
+		if _, ok := private[players[i]]; !ok {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+// getAccount returns the account of a player, or 0 if not linked.
+func getAccount(txn *sqlx.Tx, region, blizzid string) (int64, error) {
+	var account int64
+	err := txn.Get(&account, `SELECT account FROM accountlinks WHERE region = $1 AND blizzid = $2`, region, blizzid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return account, errors.Wrap(err, "get account")
+}
+
+/*
+LinkAccounts requests a link between the players region1, blizzid1 and
+region2, blizzid2. It must be a POST. The players must be in different
+regions and have shared a battletag. If either is already in an account,
+the other joins (or merges) its accounts. An account can only have one
+player per region. New players in an account are pending until confirmed
+with ConfirmAccountLink.
+*/
+func (h *hotsContext) LinkAccounts(ctx context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("must POST")
+	}
+	region1, blizzid1 := r.FormValue("region1"), r.FormValue("blizzid1")
+	region2, blizzid2 := r.FormValue("region2"), r.FormValue("blizzid2")
+	if region1 == "" || blizzid1 == "" || region2 == "" || blizzid2 == "" {
+		return nil, errors.New("region1, blizzid1, region2 and blizzid2 required")
+	}
+	if region1 == region2 {
+		return nil, errors.New("accounts must be in different regions")
+	}
+	var shared bool
+	if err := h.x.GetContext(ctx, &shared, `
+		SELECT count(*) > 0
+		FROM
+			battletags AS p
+			JOIN battletags AS o ON
+				o.battletag = p.battletag
+				AND o.battletag::STRING = p.battletag::STRING
+		WHERE
+			p.region = $1 AND p.blizzid = $2
+			AND o.region = $3 AND o.blizzid = $4
+		`, region1, blizzid1, region2, blizzid2); err != nil {
+		return nil, errors.Wrap(err, "shared battletag")
+	}
+	if !shared {
+		return nil, errors.New("accounts have never had the same battletag")
+	}
+	if err := h.txn(ctx, func(txn *sqlx.Tx) error {
+		account1, err := getAccount(txn, region1, blizzid1)
+		if err != nil {
+			return err
+		}
+		account2, err := getAccount(txn, region2, blizzid2)
+		if err != nil {
+			return err
+		}
+		if account1 != 0 && account1 == account2 {
+			return nil
+		}
+		// Check that the merged account has one player per region.
+		var regions []string
+		if err := txn.Select(&regions, `
+			SELECT region
+			FROM accountlinks
+			WHERE account IN ($1, $2)
+			`, account1, account2); err != nil {
+			return errors.Wrap(err, "regions")
+		}
+		if account1 == 0 {
+			regions = append(regions, region1)
+		}
+		if account2 == 0 {
+			regions = append(regions, region2)
+		}
+		seen := make(map[string]bool)
+		for _, r := range regions {
+			if seen[r] {
+				return errors.Errorf("already linked to another account in region %s", r)
+			}
+			seen[r] = true
+		}
+
+		account := account1
+		if account == 0 {
+			account = account2
+		}
+		if account == 0 {
+			if err := txn.Get(&account, `SELECT unique_rowid()`); err != nil {
+				return errors.Wrap(err, "new account")
+			}
+		}
+		if account1 != 0 && account2 != 0 {
+			_, err := txn.Exec(`UPDATE accountlinks SET account = $1 WHERE account = $2`, account, account2)
+			return errors.Wrap(err, "merge accounts")
+		}
+		_, err = txn.Exec(`
+			UPSERT INTO accountlinks (region, blizzid, account)
+			VALUES ($1, $2, $3), ($4, $5, $3)
+			`, region1, blizzid1, account, region2, blizzid2)
+		return errors.Wrap(err, "link")
+	}); err != nil {
+		return nil, err
+	}
+	return h.getAccountLinks(ctx, region1, blizzid1)
+}
+
+// ConfirmAccountLink confirms that a player (region and blizzid) belongs
+// to its account. It must be a POST. Players are only linked once both are
+// confirmed. Until players can sign in with Battle.net, an operator confirms
+// for them on -adminaddr.
+func (h *hotsContext) ConfirmAccountLink(ctx context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("must POST")
+	}
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	var n int64
+	if err := retry(func() error {
+		res, err := h.db.ExecContext(ctx, `
+			UPDATE accountlinks SET confirmed = true
+			WHERE region = $1 AND blizzid = $2
+			`, region, blizzid)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "confirm")
+	}
+	if n == 0 {
+		return nil, errors.New("player has no pending link")
+	}
+	return h.getAccountLinks(ctx, region, blizzid)
+}
+
+// UnlinkAccount removes a player from its linked account. It must be a
+// POST. An account left with one player is removed.
+func (h *hotsContext) UnlinkAccount(ctx context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("must POST")
+	}
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	if err := h.txn(ctx, func(txn *sqlx.Tx) error {
+		account, err := getAccount(txn, region, blizzid)
+		if err != nil || account == 0 {
+			return err
+		}
+		if _, err := txn.Exec(`DELETE FROM accountlinks WHERE region = $1 AND blizzid = $2`, region, blizzid); err != nil {
+			return errors.Wrap(err, "unlink")
+		}
+		_, err = txn.Exec(`
+			DELETE FROM accountlinks
+			WHERE account = $1 AND (SELECT count(*) FROM accountlinks WHERE account = $1) = 1
+			`, account)
+		return errors.Wrap(err, "remove account")
+	}); err != nil {
+		return nil, err
+	}
+	return h.getAccountLinks(ctx, region, blizzid)
+}
+
+// playerRequest returns a copy of r's form values as a GET request for
+// another player.
+func playerRequest(r *http.Request, region, blizzid string) *http.Request {
+	form := make(url.Values)
+	for k, v := range r.Form {
+		form[k] = v
+	}
+	form.Set("region", region)
+	form.Set("blizzid", blizzid)
+	return &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{RawQuery: form.Encode()},
+	}
+}
+
+type accountGame struct {
+	Region string
+	playerGame
+}
+
+/*
+GetAccountProfile returns the merged profile of a player and its linked
+accounts in other regions. Profile totals are summed over all regions.
+Regions has each account's full profile, including its skills. Games is
+the newest page of games over all regions. It takes the arguments of
+GetPlayerProfile and GetPlayerGames except cursor.
+*/
+func (h *hotsContext) GetAccountProfile(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	if r.FormValue("cursor") != "" {
+		return nil, errors.New("cursor not supported")
+	}
+	accounts, err := h.getLinkedAccounts(ctx, region, blizzid)
+	if err != nil {
+		return nil, err
+	}
+	res := struct {
+		Accounts []linkedAccount
+		Profile  profileTotals
+		Regions  map[string]playerProfile
+		Games    []accountGame
+	}{
+		Accounts: accounts,
+		Profile: profileTotals{
+			Heroes: make(map[string]Total),
+			Maps:   make(map[string]Total),
+			Modes:  make(map[string]Total),
+			Roles:  make(map[string]Total),
+		},
+		Regions: make(map[string]playerProfile),
+	}
+	profiles := make([]playerProfile, len(accounts))
+	games := make([]playerGames, len(accounts))
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, a := range accounts {
+		i, req := i, playerRequest(r, a.Region, a.Blizzid)
+		g.Go(func() error {
+			p, err := h.GetPlayerProfile(gCtx, req)
+			if err != nil {
+				return errors.Wrap(err, "profile")
+			}
+			profiles[i] = p.(playerProfile)
+			return nil
+		})
+		g.Go(func() error {
+			p, err := h.GetPlayerGames(gCtx, req)
+			if err != nil {
+				return errors.Wrap(err, "games")
+			}
+			games[i] = p.(playerGames)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	add := func(dst, src map[string]Total) {
+		for k, v := range src {
+			t := dst[k]
+			t.Wins += v.Wins
+			t.Losses += v.Losses
+			dst[k] = t
+		}
+	}
+	limit := defaultPlayerGamesLimit
+	if v := r.FormValue("limit"); v != "" {
+		// GetPlayerGames has validated limit.
+		limit, _ = strconv.Atoi(v)
+	}
+	for i, a := range accounts {
+		p := profiles[i]
+		res.Regions[a.Region] = p
+		add(res.Profile.Heroes, p.Profile.Heroes)
+		add(res.Profile.Maps, p.Profile.Maps)
+		add(res.Profile.Modes, p.Profile.Modes)
+		add(res.Profile.Roles, p.Profile.Roles)
+		for _, pg := range games[i].Games {
+			res.Games = append(res.Games, accountGame{Region: a.Region, playerGame: pg})
+		}
+	}
+	sort.Slice(res.Games, func(i, j int) bool {
+		return res.Games[i].Date > res.Games[j].Date
+	})
+	if len(res.Games) > limit {
+		res.Games = res.Games[:limit]
+	}
+	return res, nil
+}
@@ -35,19 +35,29 @@ import (
 )
 
 var (
-	flagInit      = flag.Bool("init", false, "drop database before starting")
-	flagAddr      = flag.String("addr", ":4001", "address to serve; HTTP redirect address if -autocert is set")
-	flagAutocert  = flag.String("autocert", "", "domain name to autocert")
-	flagAcmedir   = flag.String("acmedir", "", "optional acme directory; can be used to configure dev letsencrypt")
-	flagCockroach = flag.String("cockroach", "postgresql://root@localhost:26257/hots?sslmode=disable", "cockroach connection URL")
-	flagElo       = flag.Bool("elo", false, "run elo update")
-	flagMigrate   = flag.Bool("migrate", false, "run migration")
-	flagCron      = flag.Bool("cron", false, "run cronjob")
-	flagUpdateNew = flag.String("updatenew", "", "run new update to specified gs bucket")
-	flagImport    = flag.String("import", "csv2.hots.dog", "import from bucket")
-	flagImportNum = flag.Int("importnum", -1, "max id to import; set to 0 for first block only")
-	flagUpdateDB  = flag.Bool("updatedb", false, "update db from import bucket")
-	initDB        = false
+	flagInit       = flag.Bool("init", false, "drop database before starting")
+	flagAddr       = flag.String("addr", ":4001", "address to serve; HTTP redirect address if -autocert is set")
+	flagAutocert   = flag.String("autocert", "", "domain name to autocert")
+	flagAcmedir    = flag.String("acmedir", "", "optional acme directory; can be used to configure dev letsencrypt")
+	flagCockroach  = flag.String("cockroach", "postgresql://root@localhost:26257/hots?sslmode=disable", "cockroach connection URL")
+	flagElo        = flag.Bool("elo", false, "run elo update")
+	flagMigrate    = flag.Bool("migrate", false, "run migration")
+	flagCron       = flag.Bool("cron", false, "run cronjob")
+	flagUpdateNew  = flag.String("updatenew", "", "run new update to specified gs bucket")
+	flagImport     = flag.String("import", "csv2.hots.dog", "import from bucket")
+	flagImportNum  = flag.Int("importnum", -1, "max id to import; set to 0 for first block only")
+	flagUpdateDB   = flag.Bool("updatedb", false, "update db from import bucket")
+	flagWinFactors = flag.Bool("winfactors", false, "run win factors analysis")
+	flagResegment  = flag.Bool("resegment", false, "split imported builds at the hotfixes in the import bucket config")
+	flagLeavers    = flag.Bool("leavers", false, "flag games with probable leavers")
+	flagHeroGames  = flag.Bool("herogames", false, "count games played on each hero before each game")
+	flagCombos     = flag.Bool("combos", false, "run hero combo mining")
+	flagSimilarity = flag.Bool("similarity", false, "run hero similarity analysis")
+	flagStatDists  = flag.Bool("statdists", false, "compute hero score stat distributions")
+	flagBattletags = flag.Bool("battletags", false, "rebuild battletag history")
+	flagCoplay     = flag.Bool("coplay", false, "add games missing from co-play edges")
+	flagAdminAddr  = flag.String("adminaddr", "", "address to serve unauthenticated admin endpoints on; must not be public")
+	initDB         = false
 
 	popularGameLimit    = 10
 	leaderboardMinGames = 50
@@ -137,6 +147,34 @@ func main() {
 	//		return
 	//	}
 	//
+	//	if *flagWinFactors {
+	//		if err := h.winFactors(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//
+	//	if *flagCombos {
+	//		if err := h.heroCombos(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//
+	//	if *flagSimilarity {
+	//		if err := h.heroSimilarity(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//
+	//	if *flagStatDists {
+	//		if err := h.heroStatDists(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//
 	//	if *flagCron {
 	//		if err := h.cronLoop(); err != nil {
 	//			log.Fatalf("%+v", err)
@@ -149,6 +187,36 @@ func main() {
 	//		}
 	//		return
 	//	}
+	//	if *flagResegment {
+	//		if err := h.resegment(*flagImport); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//	if *flagLeavers {
+	//		if err := h.markAllLeavers(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//	if *flagHeroGames {
+	//		if err := h.countAllHeroGames(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//	if *flagBattletags {
+	//		if err := h.updateAllBattletags(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
+	//	if *flagCoplay {
+	//		if err := h.updateAllCoplay(); err != nil {
+	//			log.Fatalf("%+v", err)
+	//		}
+	//		return
+	//	}
 	//
 	//	h.mu.cache = make(map[string]cache)
 	//
@@ -234,20 +302,47 @@ func main() {
 	//	mux := http.NewServeMux()
 	//
 	//	mux.Handle("/api/init", wrap(h.Init))
+	//	mux.Handle("/api/get-account-links", wrap(h.respectPrivacy(h.GetAccountLinks)))
+	//	mux.Handle("/api/get-account-profile", wrap(h.respectPrivacy(h.GetAccountProfile)))
 	//	mux.Handle("/api/get-build-winrates", wrap(h.GetBuildWinrates))
 	//	mux.Handle("/api/get-compare-hero", wrap(h.GetCompareHero))
 	//	mux.Handle("/api/get-game-data", wrap(h.GetGameData))
+	//	mux.Handle("/api/get-hero-combos", wrap(h.GetHeroCombos))
 	//	mux.Handle("/api/get-hero-data", wrap(h.GetRelativeWinrates))
+	//	mux.Handle("/api/get-hero-similarity", wrap(h.GetHeroSimilarity))
+	//	mux.Handle("/api/get-hero-timeseries", wrap(h.GetHeroTimeseries))
 	//	mux.Handle("/api/get-leaderboard", wrap(h.GetLeaderboard))
+	//	mux.Handle("/api/get-learning-curves", wrap(h.GetLearningCurves))
+	//	mux.Handle("/api/get-map-data", wrap(h.GetMapData))
+	//	mux.Handle("/api/get-matchmaking", wrap(h.GetMatchmaking))
 	//	mux.Handle("/api/get-player-by-name", wrap(h.GetPlayerName))
-	//	mux.Handle("/api/get-player-games", wrap(h.GetPlayerGames))
-	//	mux.Handle("/api/get-player-matchups", wrap(h.GetPlayerMatchups))
-	//	mux.Handle("/api/get-player-profile", wrap(h.GetPlayerProfile))
-	//	mux.Handle("/api/get-player-friends", wrap(h.GetPlayerFriends))
+	//	mux.Handle("/api/get-player-compare", wrap(h.respectPrivacy(h.GetPlayerCompare)))
+	//	mux.Handle("/api/get-player-games", wrap(h.respectPrivacy(h.GetPlayerGames)))
+	//	mux.Handle("/api/get-player-hero", wrap(h.respectPrivacy(h.GetPlayerHero)))
+	//	mux.Handle("/api/get-player-matchups", wrap(h.respectPrivacy(h.GetPlayerMatchups)))
+	//	mux.Handle("/api/get-player-network", wrap(h.respectPrivacy(h.GetPlayerNetwork)))
+	//	mux.Handle("/api/get-player-profile", wrap(h.respectPrivacy(h.GetPlayerProfile)))
+	//	mux.Handle("/api/get-player-skill-timeline", wrap(h.respectPrivacy(h.GetPlayerSkillTimeline)))
+	//	mux.Handle("/api/get-time-of-day", wrap(h.respectPrivacy(h.GetTimeOfDay)))
+	//	mux.Handle("/api/get-player-friends", wrap(h.respectPrivacy(h.GetPlayerFriends)))
 	//	mux.Handle("/api/get-winrates", wrap(h.GetWinrates))
+	//	mux.Handle("/api/get-win-factors", wrap(h.GetWinFactors))
+	//	mux.HandleFunc("/api/export-player", h.ExportPlayer)
 	//	if *flagInit {
 	//		mux.HandleFunc("/api/clear-cache", h.ClearCache)
 	//	}
+	//	if *flagAdminAddr != "" {
+	//		// Players can't yet prove they own an account, so an operator
+	//		// changes accounts for them on a private address.
+	//		admin := http.NewServeMux()
+	//		admin.Handle("/api/confirm-account-link", wrap(h.ConfirmAccountLink))
+	//		admin.Handle("/api/link-accounts", wrap(h.respectPrivacy(h.LinkAccounts)))
+	//		admin.Handle("/api/set-privacy", wrap(h.SetPrivacy))
+	//		admin.Handle("/api/unlink-account", wrap(h.UnlinkAccount))
+	//		go func() {
+	//			log.Fatal(http.ListenAndServe(*flagAdminAddr, admin))
+	//		}()
+	//	}
 	//
 	//	fileServer := http.FileServer(http.Dir("static"))
 	//	serveFiles := func(w http.ResponseWriter, r *http.Request) {
@@ -301,6 +396,9 @@ func main() {
 	//			if err := h.updateInit(context.Background()); err != nil {
 	//				panic(fmt.Sprintf("%+v", err))
 	//			}
+	//			if err := h.updatePlayerIndex(context.Background()); err != nil {
+	//				log.Printf("%+v", err)
+	//			}
 	//			// Clear the memory cache of old entries.
 	//			h.mu.Lock()
 	//			cutoff := time.Now().Add(-time.Hour).Unix()
@@ -361,11 +459,18 @@ hots.dog has shut down. Thanks for visiting.
 
 var (
 	enableDBCache = map[string]bool{
-		"/api/get-build-winrates": true,
-		"/api/get-compare-hero":   true,
-		"/api/get-hero-data":      true,
-		"/api/get-winrates":       true,
-		"/api/get-leaderboard":    true,
+		"/api/get-build-winrates":  true,
+		"/api/get-compare-hero":    true,
+		"/api/get-hero-combos":     true,
+		"/api/get-hero-data":       true,
+		"/api/get-hero-similarity": true,
+		"/api/get-hero-timeseries": true,
+		"/api/get-winrates":        true,
+		"/api/get-leaderboard":     true,
+		"/api/get-learning-curves": true,
+		"/api/get-map-data":        true,
+		"/api/get-matchmaking":     true,
+		"/api/get-win-factors":     true,
 	}
 	enableMemCache = map[string]bool{
 		"/api/init": true,
@@ -504,8 +609,11 @@ type hotsContext struct {
 
 	mu struct {
 		sync.RWMutex
-		cache map[string]cache
-		init  initData
+		cache   map[string]cache
+		init    initData
+		players *playerIndex
+		// privacySalt is the secret of private player pseudonyms.
+		privacySalt []byte
 	}
 }
 
@@ -522,8 +630,12 @@ type initData struct {
 	Maps       []string
 	Heroes     []Hero
 	BuildStats map[string]map[Mode]Stats
+	Leagues    []League
 	config     *groupConfig
 	lookups    map[string]func(string) string
+	// regionStats are skill stats by build, region and mode.
+	regionStats map[string]map[int]map[Mode]Stats
+	grandmaster int
 }
 
 func (i initData) list(name, s string) []string {
@@ -535,6 +647,47 @@ func (i initData) list(name, s string) []string {
 	return res
 }
 
+// builds returns the IDs of the builds selected by the build, from and to
+// arguments.
+func (i initData) builds(args map[string]string) ([]string, error) {
+	if b := args["build"]; b != "" && !isDays(b) {
+		return strings.Split(b, ","), nil
+	}
+	from, to, err := argDates(args)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, b := range i.Builds {
+		if !from.IsZero() && b.Finish.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !b.Start.Before(to) {
+			continue
+		}
+		res = append(res, b.ID)
+	}
+	return res, nil
+}
+
+// latestBuild returns the newest build selected by args.
+func (i initData) latestBuild(args map[string]string) (string, error) {
+	builds, err := i.builds(args)
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	for _, b := range builds {
+		if b > latest {
+			latest = b
+		}
+	}
+	if latest == "" {
+		return "", errors.New("no matching builds")
+	}
+	return latest, nil
+}
+
 func (h *hotsContext) Init(ctx context.Context, _ *http.Request) (interface{}, error) {
 	return h.getInit(), nil
 }
@@ -549,18 +702,37 @@ func (h *hotsContext) updateInit(ctx context.Context) error {
 		return err
 	}
 	c.readonly = true
+	leagues := defaultLeagues
+	if err := h.x.GetContext(ctx, &maps, "SELECT s FROM config WHERE key = $1", configLeagues); err == nil {
+		if err := json.Unmarshal(maps, &leagues); err != nil {
+			return errors.Wrap(err, "decode leagues")
+		}
+		if len(leagues.Leagues) == 0 {
+			return errors.New("no leagues configured")
+		}
+	} else if err != sql.ErrNoRows {
+		return errors.Wrap(err, "get leagues")
+	}
 	bsRows, err := h.db.QueryContext(ctx, "SELECT build, mode, data FROM skillstats")
 	if err != nil {
 		return err
 	}
 	defer bsRows.Close()
+	rsRows, err := h.db.QueryContext(ctx, "SELECT build, region, mode, data FROM regionskillstats")
+	if err != nil {
+		return err
+	}
+	defer rsRows.Close()
 	h.mu.Lock()
+	defer h.mu.Unlock()
 	h.mu.init = initData{
-		Modes:   modeNames,
-		Regions: regionNames,
-		Heroes:  heroData,
-		config:  &c,
-		lookups: make(map[string]func(string) string),
+		Modes:       modeNames,
+		Regions:     regionNames,
+		Heroes:      heroData,
+		Leagues:     leagues.Leagues,
+		config:      &c,
+		lookups:     make(map[string]func(string) string),
+		grandmaster: leagues.Grandmaster,
 	}
 	for m := range c.Map["map"] {
 		h.mu.init.Maps = append(h.mu.init.Maps, m)
@@ -612,7 +784,35 @@ func (h *hotsContext) updateInit(ctx context.Context) error {
 		}
 	}
 	h.mu.init.BuildStats = bs
-	h.mu.Unlock()
+	rs := make(map[string]map[int]map[Mode]Stats)
+	for rsRows.Next() {
+		var build string
+		var region int
+		var mode Mode
+		var data []byte
+		if err := rsRows.Scan(&build, &region, &mode, &data); err != nil {
+			return err
+		}
+		var s Stats
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		bid := h.mu.init.lookups["build"](build)
+		if bid == "" {
+			return errors.Errorf("build not found: %s", build)
+		}
+		if _, ok := rs[bid]; !ok {
+			rs[bid] = make(map[int]map[Mode]Stats)
+		}
+		if _, ok := rs[bid][region]; !ok {
+			rs[bid][region] = make(map[Mode]Stats)
+		}
+		rs[bid][region][mode] = s
+	}
+	if err := rsRows.Err(); err != nil {
+		return err
+	}
+	h.mu.init.regionStats = rs
 	return nil
 }
 
@@ -681,15 +881,23 @@ func retryable(err error) bool {
 
 func (h *hotsContext) GetBuildWinrates(ctx context.Context, r *http.Request) (interface{}, error) {
 	args := map[string]string{
-		"build":      r.FormValue("build"),
-		"hero":       r.FormValue("hero"),
-		"herolevel":  r.FormValue("herolevel"),
-		"map":        r.FormValue("map"),
-		"mode":       r.FormValue("mode"),
-		"skill_low":  r.FormValue("skill_low"),
-		"skill_high": r.FormValue("skill_high"),
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"herolevel":       r.FormValue("herolevel"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
 	}
 	init := h.getInit()
+	argsPrev, ok, err := h.getBaseline(init, args, r.FormValue("baseline"))
+	if err != nil {
+		return nil, err
+	}
 	var res struct {
 		Current       map[int]map[string]Total
 		Previous      map[int]map[string]Total
@@ -704,19 +912,14 @@ func (h *hotsContext) GetBuildWinrates(ctx context.Context, r *http.Request) (in
 		return errors.Wrap(err, "getBuildWinrates")
 	})
 	g.Go(func() error {
-		if prevBuild, ok := h.getBuildBefore(init, args["build"]); ok {
-			argsPrev := make(map[string]string, len(args))
-			for k, v := range args {
-				argsPrev[k] = v
-			}
-			var err error
-			argsPrev["build"] = prevBuild
-			res.Previous, _, _, err = h.getBuildWinrates(ctx, init, argsPrev)
-			return errors.Wrapf(err, "fetch previous build: %v", prevBuild)
+		if !ok {
+			return nil
 		}
-		return nil
+		var err error
+		res.Previous, _, _, err = h.getBuildWinrates(ctx, init, argsPrev)
+		return errors.Wrapf(err, "fetch baseline: %v", argsPrev)
 	})
-	err := g.Wait()
+	err = g.Wait()
 	m := make(map[string]talentText)
 	for _, talents := range res.Current {
 		for id := range talents {
@@ -736,9 +939,6 @@ type build struct {
 func (h *hotsContext) getBuildWinrates(
 	ctx context.Context, init initData, args map[string]string,
 ) (map[int]map[string]Total, []build, []build, error) {
-	if args["build"] == "" {
-		return nil, nil, nil, errors.New("build required")
-	}
 	if args["hero"] == "" {
 		return nil, nil, nil, errors.New("hero required")
 	}
@@ -746,20 +946,14 @@ func (h *hotsContext) getBuildWinrates(
 	groups := []string{"talents", "winner"}
 	var wheres []string
 	var params []interface{}
-	for _, key := range []string{"build", "hero", "map", "mode"} {
-		v := args[key]
-		if v == "" {
-			continue
-		}
-		if m, ok := init.config.Map[key]; ok {
-			v = m[v]
-			if v == "" {
-				return nil, nil, nil, errors.Errorf("unrecognized %s: %s", key, args[key])
-			}
-		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		groups = append(groups, key)
-		params = append(params, v)
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region", "blizzid"); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, nil, nil, err
 	}
 	hl := args["herolevel"]
 	if hl == "" {
@@ -860,14 +1054,32 @@ func (h *hotsContext) getBuildWinrates(
 	return tally, popularBuilds, winningBuilds, nil
 }
 
+// GetPlayerName searches for players by name in region, or all regions if
+// region is unset. See playerIndex.search for how names are matched.
 func (h *hotsContext) GetPlayerName(ctx context.Context, r *http.Request) (interface{}, error) {
-	name := strings.ToLower(r.FormValue("name"))
+	name := r.FormValue("name")
 	if name == "" {
 		return nil, errors.New("no name parameter")
 	}
-	region := r.FormValue("region")
-	if region == "" {
-		return nil, errors.New("no region parameter")
+	var region int
+	if v := r.FormValue("region"); v != "" {
+		var err error
+		region, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse region")
+		}
+	}
+	matches, err := h.searchPlayers(ctx, name, region)
+	if err != nil {
+		return nil, err
+	}
+	players := make([]regionPlayer, len(matches))
+	for i, m := range matches {
+		players[i] = regionPlayer{m.entry.region, m.entry.blizzid}
+	}
+	private, err := h.getPseudonyms(ctx, players)
+	if err != nil {
+		return nil, err
 	}
 
 	type entry struct {
@@ -876,69 +1088,72 @@ func (h *hotsContext) GetPlayerName(ctx context.Context, r *http.Request) (inter
 		Name   string
 		Games  int
 	}
-	res := make([]entry, 0)
-	var last string
-	seen := make(map[int64]bool)
-	for len(res) < 10 {
-		var e entry
-		err := h.x.GetContext(ctx, &e, `
-			SELECT blizzid AS id, battletag AS name, $3 AS region
-			FROM players
-			WHERE
-				battletag >= $1 COLLATE en_u_ks_level1
-				AND battletag > $2 COLLATE en_u_ks_level1
-				AND region = $3
-			ORDER BY battletag
-			LIMIT 1
-		`, name, last, region)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		if !strings.HasPrefix(strings.ToLower(e.Name), name) {
-			break
-		}
-		last = e.Name
-		if seen[e.ID] {
+	res := make([]entry, 0, len(matches))
+	var args []interface{}
+	var tuples []string
+	for _, m := range matches {
+		if _, ok := private[regionPlayer{m.entry.region, m.entry.blizzid}]; ok {
 			continue
 		}
-		seen[e.ID] = true
-		res = append(res, e)
+		res = append(res, entry{
+			ID:     m.entry.blizzid,
+			Region: m.entry.region,
+			Name:   m.entry.name,
+		})
+		tuples = append(tuples, makeValues(2, len(args)+1))
+		args = append(args, m.entry.region, m.entry.blizzid)
 	}
-
-	args := make([]interface{}, len(res)+1)
-	for i, e := range res {
-		args[i+1] = e.ID
+	if len(res) == 0 {
+		return res, nil
 	}
-	args[0] = region
 	var games []entry
 	if err := h.x.SelectContext(ctx, &games, fmt.Sprintf(`
-		SELECT count(*) AS games, blizzid AS id
+		SELECT count(*) AS games, region, blizzid AS id
 		FROM players
-		WHERE region = $1 AND blizzid IN %s
-		GROUP BY blizzid
-	`, makeValues(len(res), 2)), args...); err != nil {
+		WHERE (region, blizzid) IN (%s)
+		GROUP BY region, blizzid
+	`, strings.Join(tuples, ", ")), args...); err != nil {
 		return nil, err
 	}
-	gm := make(map[int64]entry, len(games))
+	gm := make(map[regionPlayer]int, len(games))
 	for _, g := range games {
-		gm[g.ID] = g
+		gm[regionPlayer{g.Region, g.ID}] = g.Games
 	}
 	for i := range res {
-		r := &res[i]
-		g, ok := gm[r.ID]
-		if !ok {
-			return nil, errors.New("unfound id")
-		}
-		r.Games = g.Games
+		res[i].Games = gm[regionPlayer{res[i].Region, res[i].ID}]
 	}
 	return res, nil
 }
 
+type buildSkill struct {
+	Mode   Mode
+	Build  string
+	Skill  float64
+	Stats  Stats
+	League string
+}
+
+type profileTotals struct {
+	Heroes map[string]Total
+	Maps   map[string]Total
+	Modes  map[string]Total
+	Roles  map[string]Total
+}
+
+type playerProfile struct {
+	Battletag string
+	// Battletags are all names of the player, newest first.
+	Battletags []battletagHistory
+	Profile    profileTotals
+	Skills     map[Mode]buildSkill
+	AllSkills  []buildSkill
+	BuildStats map[Mode]Stats
+	// Suspicion is set if the account looks like a smurf or boosted.
+	Suspicion *suspicion
+}
+
 func (h *hotsContext) GetPlayerProfile(ctx context.Context, r *http.Request) (interface{}, error) {
 	blizzid := r.FormValue("blizzid")
-	build := r.FormValue("build")
 	region := r.FormValue("region")
 	if blizzid == "" {
 		return nil, errors.New("no blizzid parameter")
@@ -946,8 +1161,10 @@ func (h *hotsContext) GetPlayerProfile(ctx context.Context, r *http.Request) (in
 	if region == "" {
 		return nil, errors.New("no region parameter")
 	}
-	if build == "" {
-		return nil, errors.New("no build parameter")
+	args := map[string]string{
+		"build": r.FormValue("build"),
+		"from":  r.FormValue("from"),
+		"to":    r.FormValue("to"),
 	}
 	init := h.getInit()
 	var wheres []string
@@ -966,41 +1183,15 @@ func (h *hotsContext) GetPlayerProfile(ctx context.Context, r *http.Request) (in
 		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
 		params = append(params, v)
 	}
-	skillBuild := build
-	if days, _ := strconv.Atoi(build); days > 0 && days < 100 {
-		v := time.Now().UTC().Add(-time.Hour * 24 * time.Duration(days)).Format("2006-01-02")
-		wheres = append(wheres, fmt.Sprintf("time >= $%d", len(params)+1))
-		params = append(params, v)
-		skillBuild = init.Builds[0].ID
-	} else {
-		key := "build"
-		v := init.config.Map[key][build]
-		if v == "" {
-			return nil, errors.Errorf("unrecognized %s: %s", key, build)
-		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		params = append(params, v)
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
 	}
-
-	type buildSkill struct {
-		Mode  Mode
-		Build string
-		Skill float64
-		Stats Stats
+	skillBuild, err := init.latestBuild(args)
+	if err != nil {
+		return nil, err
 	}
 
-	var res struct {
-		Battletag string
-		Profile   struct {
-			Heroes map[string]Total
-			Maps   map[string]Total
-			Modes  map[string]Total
-			Roles  map[string]Total
-		}
-		Skills     map[Mode]buildSkill
-		AllSkills  []buildSkill
-		BuildStats map[Mode]Stats
-	}
+	var res playerProfile
 	res.Skills = make(map[Mode]buildSkill)
 	res.Profile.Heroes = make(map[string]Total)
 	res.Profile.Maps = make(map[string]Total)
@@ -1019,25 +1210,74 @@ func (h *hotsContext) GetPlayerProfile(ctx context.Context, r *http.Request) (in
 				`, region, blizzid); err != nil {
 			return nil, err
 		}
+		regionID, err := strconv.Atoi(region)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse region")
+		}
+		var ranks []struct {
+			Mode Mode
+			Rank int
+		}
+		if err := h.x.SelectContext(ctx, &ranks, `
+				SELECT mode, rank
+				FROM leaderboard
+				WHERE region = $1 AND blizzid = $2
+				`, region, blizzid); err != nil {
+			return nil, err
+		}
 		for i, s := range res.AllSkills {
 			build := init.lookups["build"](s.Build)
 			res.AllSkills[i].Build = build
-			res.AllSkills[i].Stats = init.BuildStats[build][s.Mode]
+			res.AllSkills[i].Stats, _ = init.skillStats(build, regionID, s.Mode)
+			res.AllSkills[i].League = init.league(res.AllSkills[i].Stats, s.Skill)
 			if build <= skillBuild && build > res.Skills[s.Mode].Build {
 				res.Skills[s.Mode] = res.AllSkills[i]
 			}
 		}
+		// Top leaderboard players are Grandmaster in their current skill.
+		for _, r := range ranks {
+			s, ok := res.Skills[r.Mode]
+			if ok && r.Rank <= init.grandmaster && s.Build == init.Builds[0].ID {
+				s.League = grandmasterLeague
+				res.Skills[r.Mode] = s
+			}
+		}
 		sort.Slice(res.AllSkills, func(i, j int) bool {
 			return res.AllSkills[i].Build < res.AllSkills[j].Build
 		})
-		res.BuildStats = init.BuildStats[skillBuild]
+		res.BuildStats = make(map[Mode]Stats)
+		for m := range init.Modes {
+			if st, ok := init.skillStats(skillBuild, regionID, m); ok {
+				res.BuildStats[m] = st
+			}
+		}
 	}
 
-	var err error
-	res.Battletag, err = h.getBattletag(ctx, blizzid, region)
+	res.Battletags, err = h.getBattletagHistory(ctx, blizzid, region)
 	if err != nil {
 		return nil, err
 	}
+	if len(res.Battletags) > 0 {
+		res.Battletag = res.Battletags[0].Battletag
+	}
+
+	{
+		var s suspicion
+		if err := h.x.GetContext(ctx, &s, `
+				SELECT
+					score,
+					games,
+					early_winrate AS earlywinrate,
+					skill_rise AS skillrise,
+					damage_percentile AS damagepercentile
+				FROM suspicion
+				WHERE region = $1 AND blizzid = $2
+				`, region, blizzid); err == nil {
+			res.Suspicion = &s
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
 
 	var games []struct {
 		Hero   string
@@ -1077,68 +1317,47 @@ func (h *hotsContext) GetPlayerProfile(ctx context.Context, r *http.Request) (in
 	return res, nil
 }
 
-func (h *hotsContext) GetPlayerFriends(ctx context.Context, r *http.Request) (interface{}, error) {
-	blizzid := r.FormValue("blizzid")
-	region := r.FormValue("region")
-	if blizzid == "" {
-		return nil, errors.New("no blizzid parameter")
-	}
-	if region == "" {
-		return nil, errors.New("no region parameter")
-	}
-
-	res := struct {
-		Battletag string
-		Region    string
-		Friends   []struct {
-			Battletag string
-			Games     int
-			Blizzid   string
-			Winrate   float64
-		}
-	}{
-		Region: region,
-	}
-
-	if err := h.x.SelectContext(ctx, &res.Friends, `
-		SELECT
-			battletag, games, blizzid, won / games * 100 AS winrate
-		FROM
-			(
-				SELECT
-					least(o.battletag) AS battletag,
-					o.blizzid,
-					count(*) AS games,
-					count(NULLIF(o.winner, false)) AS won
-				FROM
-					players AS p
-					JOIN players AS o
-					ON
-						o.game = p.game
-						AND o.blizzid != p.blizzid
-						AND NOT (o.team != p.team)
-				WHERE p.region = $1 AND p.blizzid = $2
-				GROUP BY o.blizzid, o.battletag
-			)
-		WHERE games > 5
-		ORDER BY games DESC
-		LIMIT 40
-	`, region, blizzid); err != nil {
-		return nil, err
-	}
-
-	var err error
-	res.Battletag, err = h.getBattletag(ctx, blizzid, region)
-	if err != nil {
-		return nil, err
-	}
+type playerGame struct {
+	Game      int
+	Hero      string
+	HeroLevel int    `db:"hero_level"`
+	Date      string `db:"time"`
+	Build     string
+	Winner    bool
+	Length    int
+	Map       string
+	Mode      Mode
+	// Skill is the rating after the game, or nil if it is unrated.
+	Skill *float64
+
+	Kills                  int
+	Deaths                 int
+	Assists                int
+	HeroDamage             int `db:"hero_damage"`
+	SiegeDamage            int `db:"siege_damage"`
+	Healing                int
+	DamageTaken            int `db:"damage_taken"`
+	ExperienceContribution int `db:"experience_contribution"`
+}
 
-	return res, nil
+type playerGames struct {
+	Battletag string
+	Games     []playerGame
+	Next      string
 }
 
+const (
+	defaultPlayerGamesLimit = 50
+	maxPlayerGamesLimit     = 200
+)
+
+// GetPlayerGames returns a page of a player's games, newest first. Games can
+// be filtered by build, from, to, hero, map, mode, result (win or loss) and
+// teammate (a blizzid on the same team). limit is the page size. Next is
+// passed as cursor to get the following page, and is empty on the last
+// page.
 func (h *hotsContext) GetPlayerGames(ctx context.Context, r *http.Request) (interface{}, error) {
 	blizzid := r.FormValue("blizzid")
-	build := r.FormValue("build")
 	region := r.FormValue("region")
 	if blizzid == "" {
 		return nil, errors.New("no blizzid parameter")
@@ -1146,41 +1365,67 @@ func (h *hotsContext) GetPlayerGames(ctx context.Context, r *http.Request) (inte
 	if region == "" {
 		return nil, errors.New("no region parameter")
 	}
-	if build == "" {
-		return nil, errors.New("no build parameter")
-	}
-	var res struct {
-		Battletag string
-		Games     []struct {
-			Game      int
-			Hero      string
-			HeroLevel int    `db:"hero_level"`
-			Date      string `db:"time"`
-			Build     string
-			Winner    bool
-			Length    int
-			Map       string
-			Mode      Mode
-			Skill     *int
-		}
-	}
 
+	var res playerGames
 	init := h.getInit()
 	wheres := []string{"blizzid = $1", "region = $2"}
 	params := []interface{}{blizzid, region}
-	if days, _ := strconv.Atoi(build); days > 0 && days < 100 {
-		v := time.Now().UTC().Add(-time.Hour * 24 * time.Duration(days)).Format("2006-01-02")
-		wheres = append(wheres, fmt.Sprintf("time >= $%d", len(params)+1))
-		params = append(params, v)
-	} else {
-		key := "build"
-		v := init.config.Map[key][build]
-		if v == "" {
-			return nil, errors.Errorf("unrecognized %s: %s", key, build)
+	args := map[string]string{
+		"build": r.FormValue("build"),
+		"from":  r.FormValue("from"),
+		"to":    r.FormValue("to"),
+		"hero":  r.FormValue("hero"),
+		"map":   r.FormValue("map"),
+		"mode":  r.FormValue("mode"),
+	}
+	if err := setOptionalBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode"); err != nil {
+		return nil, err
+	}
+	switch result := r.FormValue("result"); result {
+	case "":
+	case "win":
+		wheres = append(wheres, "winner")
+	case "loss":
+		wheres = append(wheres, "NOT winner")
+	default:
+		return nil, errors.Errorf("unknown result: %s", result)
+	}
+	if teammate := r.FormValue("teammate"); teammate != "" {
+		wheres = append(wheres, fmt.Sprintf(`(game, team) IN (
+			SELECT game, team
+			FROM players
+			WHERE region = $2 AND blizzid = $%d
+		)`, len(params)+1))
+		params = append(params, teammate)
+	}
+	if cursor := r.FormValue("cursor"); cursor != "" {
+		i := strings.LastIndexByte(cursor, ',')
+		if i < 0 {
+			return nil, errors.Errorf("bad cursor: %s", cursor)
+		}
+		game, err := strconv.ParseInt(cursor[i+1:], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse cursor")
+		}
+		wheres = append(wheres, fmt.Sprintf("(time, game) < ($%d, $%d)", len(params)+1, len(params)+2))
+		params = append(params, cursor[:i], game)
+	}
+	limit := defaultPlayerGamesLimit
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse limit")
+		}
+		if limit < 1 || limit > maxPlayerGamesLimit {
+			return nil, errors.Errorf("limit must be between 1 and %d", maxPlayerGamesLimit)
 		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		params = append(params, v)
 	}
+	// Fetch one extra to know if there's a next page.
+	params = append(params, limit+1)
 
 	var err error
 	res.Battletag, err = h.getBattletag(ctx, blizzid, region)
@@ -1189,13 +1434,30 @@ func (h *hotsContext) GetPlayerGames(ctx context.Context, r *http.Request) (inte
 	}
 
 	if err := h.x.SelectContext(ctx, &res.Games, fmt.Sprintf(`
-			SELECT game, hero, hero_level, build, winner, length, map, mode, time
+			SELECT
+				game, hero, hero_level, build, winner, length, map, mode, time,
+				-- Unrated games have a skill of 0.
+				NULLIF(skill, 0) AS skill,
+				COALESCE((data->>'kills')::INT, 0) AS kills,
+				COALESCE((data->>'deaths')::INT, 0) AS deaths,
+				COALESCE((data->>'assists')::INT, 0) AS assists,
+				COALESCE((data->>'hero_damage')::INT, 0) AS hero_damage,
+				COALESCE((data->>'siege_damage')::INT, 0) AS siege_damage,
+				COALESCE((data->>'healing')::INT, 0) AS healing,
+				COALESCE((data->>'damage_taken')::INT, 0) AS damage_taken,
+				COALESCE((data->>'experience_contribution')::INT, 0) AS experience_contribution
 			FROM players
 			WHERE %s
-			ORDER BY time DESC
-			`, strings.Join(wheres, " AND ")), params...); err != nil {
+			ORDER BY time DESC, game DESC
+			LIMIT $%d
+			`, strings.Join(wheres, " AND "), len(params)), params...); err != nil {
 		return nil, err
 	}
+	if len(res.Games) > limit {
+		res.Games = res.Games[:limit]
+		last := res.Games[limit-1]
+		res.Next = fmt.Sprintf("%s,%d", last.Date, last.Game)
+	}
 	for i, g := range res.Games {
 		g.Hero = init.lookups["hero"](g.Hero)
 		g.Map = init.lookups["map"](g.Map)
@@ -1210,9 +1472,9 @@ func (h *hotsContext) getBattletag(ctx context.Context, blizzid, region string)
 	var battletag string
 	err := h.x.GetContext(ctx, &battletag, `
 		SELECT battletag
-		FROM players
+		FROM battletags
 		WHERE blizzid = $1 and region = $2
-		ORDER BY time DESC
+		ORDER BY last_seen DESC
 		LIMIT 1
 		`, blizzid, region)
 	return battletag, err
@@ -1220,7 +1482,6 @@ func (h *hotsContext) getBattletag(ctx context.Context, blizzid, region string)
 
 func (h *hotsContext) GetPlayerMatchups(ctx context.Context, r *http.Request) (interface{}, error) {
 	blizzid := r.FormValue("blizzid")
-	build := r.FormValue("build")
 	region := r.FormValue("region")
 	if blizzid == "" {
 		return nil, errors.New("no blizzid parameter")
@@ -1228,9 +1489,6 @@ func (h *hotsContext) GetPlayerMatchups(ctx context.Context, r *http.Request) (i
 	if region == "" {
 		return nil, errors.New("no region parameter")
 	}
-	if build == "" {
-		return nil, errors.New("no build parameter")
-	}
 	res := struct {
 		Battletag string
 		Same      map[string]Total
@@ -1243,18 +1501,12 @@ func (h *hotsContext) GetPlayerMatchups(ctx context.Context, r *http.Request) (i
 	init := h.getInit()
 	wheres := []string{"blizzid = $1", "region = $2"}
 	params := []interface{}{blizzid, region}
-	if days, _ := strconv.Atoi(build); days > 0 && days < 100 {
-		v := time.Now().UTC().Add(-time.Hour * 24 * time.Duration(days)).Format("2006-01-02")
-		wheres = append(wheres, fmt.Sprintf("time >= $%d", len(params)+1))
-		params = append(params, v)
-	} else {
-		key := "build"
-		v := init.config.Map[key][build]
-		if v == "" {
-			return nil, errors.Errorf("unrecognized %s: %s", key, build)
-		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		params = append(params, v)
+	if err := setBuildParams(init, &wheres, &params, map[string]string{
+		"build": r.FormValue("build"),
+		"from":  r.FormValue("from"),
+		"to":    r.FormValue("to"),
+	}); err != nil {
+		return nil, err
 	}
 
 	var err error
@@ -1331,25 +1583,16 @@ func (h *hotsContext) GetGameData(ctx context.Context, r *http.Request) (interfa
 			Build   string
 			Bans    string `json:"-"`
 			BanList []string
+			Leaver  bool
 		}
-		Players []*struct {
-			Hero       string
-			HeroLevel  int `db:"hero_level"`
-			Winner     bool
-			Blizzid    int
-			Battletag  string
-			Talents    string `json:"-"`
-			TalentList []string
-			Data       json.RawMessage
-			Region     int
-		}
+		Players []*gamePlayer
 		Talents map[string]talentText
 	}{
 		Talents: make(map[string]talentText),
 	}
 
 	if err := h.x.GetContext(ctx, &res.Game, `
-		SELECT mode, time, map, length, build, bans
+		SELECT mode, time, map, length, build, bans, leaver
 		FROM games
 		WHERE id = $1
 		`, id); err == sql.ErrNoRows {
@@ -1358,19 +1601,15 @@ func (h *hotsContext) GetGameData(ctx context.Context, r *http.Request) (interfa
 		return nil, err
 	}
 
-	if err := h.x.SelectContext(ctx, &res.Players, `
-		SELECT hero, hero_level, winner, blizzid, battletag, talents, data, region
-		FROM players
-		WHERE game = $1
-		`, id); err != nil {
+	var err error
+	res.Players, err = h.getGamePlayers(ctx, init, "game = $1", id)
+	if err != nil {
 		return nil, err
 	}
 	res.Game.BanList = init.list("hero", res.Game.Bans)
 	res.Game.Map = init.lookups["map"](res.Game.Map)
 	res.Game.Build = init.lookups["build"](res.Game.Build)
 	for _, p := range res.Players {
-		p.Hero = init.lookups["hero"](p.Hero)
-		p.TalentList = init.list("talent", p.Talents)
 		for _, t := range p.TalentList {
 			res.Talents[t] = talentData[t]
 		}
@@ -1379,21 +1618,83 @@ func (h *hotsContext) GetGameData(ctx context.Context, r *http.Request) (interfa
 	return res, nil
 }
 
+type gamePlayer struct {
+	Game       int64 `json:"-"`
+	Hero       string
+	HeroLevel  int `db:"hero_level"`
+	Winner     bool
+	Blizzid    int
+	Battletag  string
+	Talents    string `json:"-"`
+	TalentList []string
+	Data       json.RawMessage
+	Region     int
+	Team       int
+}
+
+// getGamePlayers returns the players matching where with private players
+// anonymized.
+func (h *hotsContext) getGamePlayers(
+	ctx context.Context, init initData, where string, params ...interface{},
+) ([]*gamePlayer, error) {
+	var res []*gamePlayer
+	if err := h.x.SelectContext(ctx, &res, fmt.Sprintf(`
+		SELECT game, hero, hero_level, winner, blizzid, battletag, talents, data, region, team
+		FROM players
+		WHERE %s
+		`, where), params...); err != nil {
+		return nil, err
+	}
+	players := make([]regionPlayer, len(res))
+	for i, p := range res {
+		players[i] = regionPlayer{p.Region, int64(p.Blizzid)}
+	}
+	pseudonyms, err := h.getPseudonyms(ctx, players)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range res {
+		if name, ok := pseudonyms[players[i]]; ok {
+			p.Battletag = name
+			p.Blizzid = 0
+		}
+		p.Hero = init.lookups["hero"](p.Hero)
+		p.TalentList = init.list("talent", p.Talents)
+	}
+	return res, nil
+}
+
 type heroRelativeData struct {
 	Base    map[string]Total
 	Lengths map[string]Total
 	Levels  map[string]Total
-	Maps    map[string]Total
-	Modes   map[string]Total
-	Leagues map[string]Total
+	// Experience groups by the number of games played on the hero before.
+	Experience map[string]Total
+	Maps       map[string]Total
+	Modes      map[string]Total
+	Leagues    map[string]Total
 }
 
 func (h *hotsContext) GetRelativeWinrates(
 	ctx context.Context, r *http.Request,
 ) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
 	init := h.getInit()
-	build := r.FormValue("build")
-	hero := r.FormValue("hero")
+	argsPrev, ok, err := h.getBaseline(init, args, r.FormValue("baseline"))
+	if err != nil {
+		return nil, err
+	}
 	var res struct {
 		Current  heroRelativeData
 		Previous heroRelativeData
@@ -1401,38 +1702,52 @@ func (h *hotsContext) GetRelativeWinrates(
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		var err error
-		res.Current, err = h.getRelativeWinrates(ctx, init, build, hero)
+		res.Current, err = h.getRelativeWinrates(ctx, init, args)
 		return errors.Wrap(err, "getHero current build")
 	})
 	g.Go(func() error {
-		if prevBuild, ok := h.getBuildBefore(init, build); ok {
-			var err error
-			res.Previous, err = h.getRelativeWinrates(ctx, init, prevBuild, hero)
-			return errors.Wrap(err, "getHero previous build")
+		if !ok {
+			return nil
 		}
-		return nil
+		var err error
+		res.Previous, err = h.getRelativeWinrates(ctx, init, argsPrev)
+		return errors.Wrap(err, "getHero baseline")
 	})
-	err := g.Wait()
+	err = g.Wait()
 	return res, err
 }
 
 func (h *hotsContext) getRelativeWinrates(
-	ctx context.Context, init initData, build, hero string,
+	ctx context.Context, init initData, args map[string]string,
 ) (heroRelativeData, error) {
-	params := []interface{}{
-		init.config.build(build),
-		init.config.hero(hero),
-	}
 	var res heroRelativeData
+	if args["hero"] == "" {
+		return res, errors.New("hero required")
+	}
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return res, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region"); err != nil {
+		return res, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return res, err
+	}
+	if err := setSkillParams(init, &wheres, &params, args); err != nil {
+		return res, err
+	}
+	where := strings.Join(wheres, " AND ")
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		var err error
-		res.Base, err = h.countWins(ctx, nil, `
+		res.Base, err = h.countWins(ctx, nil, fmt.Sprintf(`
 				SELECT COUNT(*) count, winner, '' counter
 				FROM players
-				WHERE build = $1 AND hero = $2
+				WHERE %s
 				GROUP BY winner
-			`, params)
+			`, where), params)
 		if res.Base != nil && len(res.Base) == 0 {
 			res.Base[""] = Total{}
 		}
@@ -1440,28 +1755,28 @@ func (h *hotsContext) getRelativeWinrates(
 	})
 	g.Go(func() error {
 		var err error
-		res.Maps, err = h.countWins(ctx, init.lookups["map"], `
+		res.Maps, err = h.countWins(ctx, init.lookups["map"], fmt.Sprintf(`
 				SELECT COUNT(*) count, winner, map counter
 				FROM players
-				WHERE build = $1 AND hero = $2
+				WHERE %s
 				GROUP BY winner, map
-			`, params)
+			`, where), params)
 		return errors.Wrap(err, "maps")
 	})
 	g.Go(func() error {
 		var err error
-		res.Modes, err = h.countWins(ctx, nil, `
+		res.Modes, err = h.countWins(ctx, nil, fmt.Sprintf(`
 				SELECT COUNT(*) count, winner, mode counter
 				FROM players
-				WHERE build = $1 AND hero = $2
+				WHERE %s
 				GROUP BY winner, mode
-			`, params)
+			`, where), params)
 		return errors.Wrap(err, "modes")
 	})
 	g.Go(func() error {
 		var err error
 		// Group hero levels by 5s.
-		res.Levels, err = h.countWins(ctx, nil, `
+		res.Levels, err = h.countWins(ctx, nil, fmt.Sprintf(`
 				SELECT
 					count(*) AS count,
 					winner,
@@ -1470,52 +1785,72 @@ func (h *hotsContext) getRelativeWinrates(
 					(
 						SELECT winner, hero_level // 5 AS counter
 						FROM players
-						WHERE build = $1 AND hero = $2
+						WHERE %s
 					)
 				GROUP BY winner, counter
-			`, params)
+			`, where), params)
 		return errors.Wrap(err, "hero level")
 	})
+	g.Go(func() error {
+		var err error
+		res.Experience, err = h.countWins(ctx, nil, fmt.Sprintf(`
+				SELECT count(*) AS count, winner, counter
+				FROM (
+					SELECT winner, %s AS counter
+					FROM players
+					WHERE %s AND hero_games IS NOT NULL
+				)
+				GROUP BY winner, counter
+			`, heroGamesCase(), where), params)
+		return errors.Wrap(err, "experience")
+	})
 	g.Go(func() error {
 		var err error
 		// Group game lengths in 5 minute blocks.
-		res.Lengths, err = h.countWins(ctx, nil, `
+		res.Lengths, err = h.countWins(ctx, nil, fmt.Sprintf(`
 				SELECT count(*) count, winner, counter * 60 * 5 as counter
 				FROM (
 					SELECT winner, round(length / 60 / 5) as counter
 					FROM players
-					WHERE build = $1 AND hero = $2
+					WHERE %s
 				)
 				GROUP BY winner, counter
-			`, params)
+			`, where), params)
 		return errors.Wrap(err, "length")
 	})
 	g.Go(func() error {
-		modes, ok := init.BuildStats[build]
-		if !ok {
-			return errors.Errorf("unknown build: %s", build)
+		scopes, err := init.skillScopes(args)
+		if err != nil {
+			return err
 		}
 		var sb strings.Builder
 		sb.WriteString("case\n")
-		for m := range init.Modes {
-			quantiles := modes[m].Quantile
-			if quantiles[99] == 0 {
+		found := false
+		for _, sc := range scopes {
+			if sc.stats.Count == 0 {
 				continue
 			}
-			for i := 1; i <= len(skillQuantiles); i++ {
-				fmt.Fprintf(&sb, "WHEN mode = %d", m)
+			quantiles := sc.stats.Quantile
+			found = true
+			for i := 1; i <= len(init.Leagues); i++ {
+				fmt.Fprintf(&sb, "WHEN build = %s AND mode = %d", init.config.build(sc.build), sc.mode)
+				if sc.region != 0 {
+					fmt.Fprintf(&sb, " AND region = %d", sc.region)
+				}
 				if i > 1 {
-					fmt.Fprintf(&sb, " AND skill > %f", quantiles[skillQuantiles[i-1]])
+					fmt.Fprintf(&sb, " AND skill > %f", quantiles[init.Leagues[i-1].Quantile])
 				}
-				if i < len(skillQuantiles) {
-					fmt.Fprintf(&sb, " AND skill <= %f", quantiles[skillQuantiles[i]])
+				if i < len(init.Leagues) {
+					fmt.Fprintf(&sb, " AND skill <= %f", quantiles[init.Leagues[i].Quantile])
 				}
 				fmt.Fprintf(&sb, " THEN %d\n", i)
 			}
 		}
+		if !found {
+			return errors.Errorf("unknown build: %s", args["build"])
+		}
 		sb.WriteString("\nend")
 
-		var err error
 		res.Leagues, err = h.countWins(ctx, nil, fmt.Sprintf(`
 			SELECT
 				count(*) AS count, winner, counter
@@ -1526,11 +1861,11 @@ func (h *hotsContext) getRelativeWinrates(
 					FROM
 						players
 					WHERE
-						build = $1 AND hero = $2
+						%s
 				)
 			GROUP BY
 				winner, counter
-		`, sb.String()), params)
+		`, sb.String(), where), params)
 		return errors.Wrap(err, "league")
 	})
 	err := g.Wait()
@@ -1574,14 +1909,22 @@ func (h *hotsContext) getInit() initData {
 
 func (h *hotsContext) GetWinrates(ctx context.Context, r *http.Request) (interface{}, error) {
 	args := map[string]string{
-		"build":      r.FormValue("build"),
-		"herolevel":  r.FormValue("herolevel"),
-		"map":        r.FormValue("map"),
-		"mode":       r.FormValue("mode"),
-		"skill_low":  r.FormValue("skill_low"),
-		"skill_high": r.FormValue("skill_high"),
+		"build":           r.FormValue("build"),
+		"herolevel":       r.FormValue("herolevel"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
 	}
 	init := h.getInit()
+	argsPrev, ok, err := h.getBaseline(init, args, r.FormValue("baseline"))
+	if err != nil {
+		return nil, err
+	}
 	var res struct {
 		Current  map[string]Total
 		Previous map[string]Total
@@ -1593,19 +1936,14 @@ func (h *hotsContext) GetWinrates(ctx context.Context, r *http.Request) (interfa
 		return errors.Wrap(err, "getWinrates current build")
 	})
 	g.Go(func() error {
-		if prevBuild, ok := h.getBuildBefore(init, args["build"]); ok {
-			argsPrev := make(map[string]string, len(args))
-			for k, v := range args {
-				argsPrev[k] = v
-			}
-			var err error
-			argsPrev["build"] = prevBuild
-			res.Previous, err = h.getWinrates(ctx, init, argsPrev)
-			return errors.Wrap(err, "getWinrates previous build")
+		if !ok {
+			return nil
 		}
-		return nil
+		var err error
+		res.Previous, err = h.getWinrates(ctx, init, argsPrev)
+		return errors.Wrap(err, "getWinrates baseline")
 	})
-	err := g.Wait()
+	err = g.Wait()
 	return res, err
 }
 
@@ -1614,26 +1952,17 @@ const defaultHerolevel = "5"
 func (h *hotsContext) getWinrates(
 	ctx context.Context, init initData, args map[string]string,
 ) (map[string]Total, error) {
-	if args["build"] == "" {
-		return nil, errors.New("build required")
-	}
-
 	groups := []string{"hero", "winner"}
 	var wheres []string
 	var params []interface{}
-	for _, key := range []string{"build", "map", "mode"} {
-		v := args[key]
-		if v == "" {
-			continue
-		}
-		if m, ok := init.config.Map[key]; ok {
-			v = m[v]
-			if v == "" {
-				return nil, errors.Errorf("unrecognized %s: %s", key, args[key])
-			}
-		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		params = append(params, v)
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
 	}
 	hl := args["herolevel"]
 	if hl == "" {
@@ -1655,87 +1984,348 @@ func (h *hotsContext) getWinrates(
 	return h.countWins(ctx, init.lookups["hero"], buf.String(), params)
 }
 
-func setSkillParams(
+// setBuildParams adds filters for the build, from and to arguments. build
+// may be a comma-separated list of builds. from and to are inclusive dates
+// (2006-01-02). For compatibility a build of less than 100 is the number
+// of days before now.
+func setBuildParams(
 	init initData, wheres *[]string, params *[]interface{}, args map[string]string,
 ) error {
-	sl, sh := args["skill_low"], args["skill_high"]
-	if sl == "" && sh == "" {
-		return nil
+	from, to, err := argDates(args)
+	if err != nil {
+		return err
 	}
-	var ms []Mode
-	if m := args["mode"]; m != "" {
-		i, err := strconv.Atoi(m)
+	build := args["build"]
+	if isDays(build) {
+		build = ""
+	}
+	if build == "" && from.IsZero() {
+		return errors.New("build or from required")
+	}
+	if build != "" {
+		var ids []interface{}
+		for _, b := range strings.Split(build, ",") {
+			v := init.config.Map["build"][b]
+			if v == "" {
+				return errors.Errorf("unrecognized build: %s", b)
+			}
+			ids = append(ids, v)
+		}
+		if len(ids) == 1 {
+			*wheres = append(*wheres, fmt.Sprintf("build = $%d", len(*params)+1))
+		} else {
+			*wheres = append(*wheres, fmt.Sprintf("build IN %s", makeValues(len(ids), len(*params)+1)))
+		}
+		*params = append(*params, ids...)
+	}
+	if !from.IsZero() {
+		*wheres = append(*wheres, fmt.Sprintf("time >= $%d", len(*params)+1))
+		*params = append(*params, from)
+	}
+	if !to.IsZero() {
+		*wheres = append(*wheres, fmt.Sprintf("time < $%d", len(*params)+1))
+		*params = append(*params, to)
+	}
+	return nil
+}
+
+// setOptionalBuildParams is setBuildParams for endpoints that don't need a
+// build or dates: nothing is added if none are set, and to may be set alone.
+func setOptionalBuildParams(
+	init initData, wheres *[]string, params *[]interface{}, args map[string]string,
+) error {
+	if args["build"] != "" || args["from"] != "" {
+		return setBuildParams(init, wheres, params, args)
+	}
+	_, to, err := argDates(args)
+	if err != nil {
+		return err
+	}
+	if !to.IsZero() {
+		*wheres = append(*wheres, fmt.Sprintf("time < $%d", len(*params)+1))
+		*params = append(*params, to)
+	}
+	return nil
+}
+
+const dateFormat = "2006-01-02"
+
+// isDays reports whether build is a number of days instead of a build.
+func isDays(build string) bool {
+	days, _ := strconv.Atoi(build)
+	return days > 0 && days < 100
+}
+
+// argDates returns the date range selected by args. to is exclusive and
+// is the day after the to argument. Either may be zero if unset.
+func argDates(args map[string]string) (from, to time.Time, err error) {
+	if isDays(args["build"]) {
+		days, _ := strconv.Atoi(args["build"])
+		from = time.Now().UTC().Add(-time.Hour * 24 * time.Duration(days)).Truncate(time.Hour * 24)
+	}
+	if v := args["from"]; v != "" {
+		from, err = time.Parse(dateFormat, v)
 		if err != nil {
-			return err
+			return from, to, errors.Wrap(err, "parse from")
 		}
-		ms = append(ms, Mode(i))
 	}
-	if len(ms) == 0 {
-		for m := range init.Modes {
-			ms = append(ms, m)
+	if v := args["to"]; v != "" {
+		to, err = time.Parse(dateFormat, v)
+		if err != nil {
+			return from, to, errors.Wrap(err, "parse to")
 		}
+		to = to.AddDate(0, 0, 1)
 	}
-	modes, ok := init.BuildStats[args["build"]]
-	if !ok {
-		return errors.Errorf("unknown build: %s", args["build"])
+	return from, to, nil
+}
+
+// setArgParams adds a filter for each of keys that is set in args. A value
+// may be a comma-separated list to match any of them. Values are translated
+// through the config map if one exists.
+func setArgParams(
+	init initData, wheres *[]string, params *[]interface{}, args map[string]string, keys ...string,
+) error {
+	for _, key := range keys {
+		if args[key] == "" {
+			continue
+		}
+		var vs []interface{}
+		for _, v := range strings.Split(args[key], ",") {
+			if m, ok := init.config.Map[key]; ok {
+				id := m[v]
+				if id == "" {
+					return errors.Errorf("unrecognized %s: %s", key, v)
+				}
+				v = id
+			}
+			vs = append(vs, v)
+		}
+		if len(vs) == 1 {
+			*wheres = append(*wheres, fmt.Sprintf("%s = $%d", key, len(*params)+1))
+		} else {
+			*wheres = append(*wheres, fmt.Sprintf("%s IN %s", key, makeValues(len(vs), len(*params)+1)))
+		}
+		*params = append(*params, vs...)
+	}
+	return nil
+}
+
+func setSkillParams(
+	init initData, wheres *[]string, params *[]interface{}, args map[string]string,
+) error {
+	sl, sh := args["skill_low"], args["skill_high"]
+	if sl == "" && sh == "" {
+		return nil
+	}
+	scopes, err := init.skillScopes(args)
+	if err != nil {
+		return err
 	}
 	var allModes []string
-	for _, m := range ms {
-		var modeWhere []string
-		quantiles := modes[m].Quantile
+	for _, sc := range scopes {
+		modeWhere := []string{fmt.Sprintf("build = $%d", len(*params)+1)}
+		*params = append(*params, init.config.build(sc.build))
+		if sc.region != 0 {
+			modeWhere = append(modeWhere, fmt.Sprintf("region = %d", sc.region))
+		}
+		quantiles := sc.stats.Quantile
 		if sl != "" {
-			modeWhere = append(modeWhere, fmt.Sprintf("skill >= $%d", len(*params)+1))
 			i, err := strconv.Atoi(sl)
 			if err != nil {
 				return err
 			}
-
-			*params = append(*params, quantiles[skillQuantiles[i]])
+			if i < 0 || i >= len(init.Leagues) {
+				return errors.Errorf("unknown skill_low: %s", sl)
+			}
+			modeWhere = append(modeWhere, fmt.Sprintf("skill >= $%d", len(*params)+1))
+			*params = append(*params, quantiles[init.Leagues[i].Quantile])
 		}
 		if sh != "" {
-			modeWhere = append(modeWhere, fmt.Sprintf("skill <= $%d", len(*params)+1))
 			i, err := strconv.Atoi(sh)
 			if err != nil {
 				return err
 			}
-			*params = append(*params, quantiles[skillQuantiles[i+1]])
+			if i < 0 || i >= len(init.Leagues) {
+				return errors.Errorf("unknown skill_high: %s", sh)
+			}
+			// The highest league has no upper bound.
+			if i+1 < len(init.Leagues) {
+				modeWhere = append(modeWhere, fmt.Sprintf("skill <= $%d", len(*params)+1))
+				*params = append(*params, quantiles[init.Leagues[i+1].Quantile])
+			}
 		}
-		allModes = append(allModes, fmt.Sprintf("(mode = %d AND (%s))", m, strings.Join(modeWhere, " AND ")))
-
+		allModes = append(allModes, fmt.Sprintf("(mode = %d AND %s)", sc.mode, strings.Join(modeWhere, " AND ")))
+	}
+	if len(allModes) == 0 {
+		return errors.Errorf("unknown build: %s", args["build"])
 	}
 	*wheres = append(*wheres, fmt.Sprintf("(%s)", strings.Join(allModes, " OR ")))
 	return nil
 }
 
+// skillScope is the skill distribution of a build, region and mode.
+type skillScope struct {
+	build string
+	// region is 0 if the stats are for all regions.
+	region int
+	mode   Mode
+	stats  Stats
+}
+
+// skillScopes returns the skill stats for the builds, regions and modes
+// selected by args. If args filters by region, scopes are returned per
+// region so region-specific stats are used where they exist.
+func (i initData) skillScopes(args map[string]string) ([]skillScope, error) {
+	var ms []Mode
+	if v := args["mode"]; v != "" {
+		for _, m := range strings.Split(v, ",") {
+			i, err := strconv.Atoi(m)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, Mode(i))
+		}
+	} else {
+		for m := range i.Modes {
+			ms = append(ms, m)
+		}
+	}
+	regions := []int{0}
+	if v := args["region"]; v != "" {
+		regions = regions[:0]
+		for _, r := range strings.Split(v, ",") {
+			i, err := strconv.Atoi(r)
+			if err != nil {
+				return nil, err
+			}
+			regions = append(regions, i)
+		}
+	}
+	builds, err := i.builds(args)
+	if err != nil {
+		return nil, err
+	}
+	var scopes []skillScope
+	for _, b := range builds {
+		for _, r := range regions {
+			for _, m := range ms {
+				st, ok := i.skillStats(b, r, m)
+				if !ok {
+					continue
+				}
+				scopes = append(scopes, skillScope{
+					build:  b,
+					region: r,
+					mode:   m,
+					stats:  st,
+				})
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// skillStats returns the skill stats of a build and mode in region, or
+// across all regions if region is 0. Regions without their own stats use
+// the stats across all regions.
+func (i initData) skillStats(build string, region int, mode Mode) (Stats, bool) {
+	if st, ok := i.regionStats[build][region][mode]; ok {
+		return st, true
+	}
+	st, ok := i.BuildStats[build][mode]
+	return st, ok
+}
+
+// league returns the name of the league skill falls in according to st.
+func (i initData) league(st Stats, skill float64) string {
+	if st.Count == 0 || len(i.Leagues) == 0 {
+		return ""
+	}
+	name := i.Leagues[0].Name
+	for _, l := range i.Leagues[1:] {
+		if skill <= st.Quantile[l.Quantile] {
+			break
+		}
+		name = l.Name
+	}
+	return name
+}
+
 type Total struct {
 	Wins, Losses int
 }
 
+// heroComparison is a hero's winrate with and against other heroes.
+type heroComparison struct {
+	SameTeam  map[string]Total
+	OtherTeam map[string]Total
+	Total     Total
+}
+
+// GetCompareHero returns a hero's winrate with and against other heroes.
+// Previous is only set if baseline is, unlike the winrate endpoints which
+// default to the previous build, so the common uncompared request stays a
+// single query.
 func (h *hotsContext) GetCompareHero(ctx context.Context, r *http.Request) (interface{}, error) {
 	init := h.getInit()
 	args := map[string]string{
-		"build":     init.config.build(r.FormValue("build")),
-		"hero":      init.config.hero(r.FormValue("hero")),
-		"herolevel": r.FormValue("herolevel"),
-		"map":       init.config.gamemap(r.FormValue("map")),
-		"mode":      r.FormValue("mode"),
-	}
-	if args["build"] == "" {
-		return nil, errors.New("build required")
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"herolevel":       r.FormValue("herolevel"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
 	}
 	if args["hero"] == "" {
 		return nil, errors.New("hero required")
 	}
+	var res struct {
+		heroComparison
+		Previous *heroComparison
+	}
+	var argsPrev map[string]string
+	if baseline := r.FormValue("baseline"); baseline != "" {
+		var err error
+		argsPrev, _, err = h.getBaseline(init, args, baseline)
+		if err != nil {
+			return nil, err
+		}
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		res.heroComparison, err = h.getCompareHero(ctx, init, args)
+		return errors.Wrap(err, "getCompareHero")
+	})
+	g.Go(func() error {
+		if argsPrev == nil {
+			return nil
+		}
+		prev, err := h.getCompareHero(ctx, init, argsPrev)
+		res.Previous = &prev
+		return errors.Wrapf(err, "fetch baseline: %v", argsPrev)
+	})
+	err := g.Wait()
+	return res, err
+}
 
+func (h *hotsContext) getCompareHero(ctx context.Context, init initData, args map[string]string) (heroComparison, error) {
+	var res heroComparison
 	var wheres []string
 	var params []interface{}
-	for _, key := range []string{"build", "hero", "map", "mode"} {
-		v := args[key]
-		if v == "" {
-			continue
-		}
-		wheres = append(wheres, fmt.Sprintf("%s = $%d", key, len(params)+1))
-		params = append(params, v)
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return res, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region"); err != nil {
+		return res, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return res, err
 	}
 	hl := args["herolevel"]
 	if hl == "" {
@@ -1743,13 +2333,8 @@ func (h *hotsContext) GetCompareHero(ctx context.Context, r *http.Request) (inte
 	}
 	wheres = append(wheres, fmt.Sprintf("hero_level >= $%d", len(params)+1))
 	params = append(params, hl)
-	if err := setSkillParams(init, &wheres, &params, map[string]string{
-		"mode":       r.FormValue("mode"),
-		"build":      r.FormValue("build"),
-		"skill_low":  r.FormValue("skill_low"),
-		"skill_high": r.FormValue("skill_high"),
-	}); err != nil {
-		return nil, err
+	if err := setSkillParams(init, &wheres, &params, args); err != nil {
+		return res, err
 	}
 	gameQuery := fmt.Sprintf(`
 		SELECT game, team
@@ -1769,17 +2354,17 @@ func (h *hotsContext) GetCompareHero(ctx context.Context, r *http.Request) (inte
 		`,
 		gameQuery,
 	)
-	var res []struct {
+	var counts []struct {
 		Hero     string
 		Sameteam bool
 		Winner   bool
 		Count    int
 	}
-	if err := h.x.SelectContext(ctx, &res, query, params...); err != nil {
-		return nil, err
+	if err := h.x.SelectContext(ctx, &counts, query, params...); err != nil {
+		return res, err
 	}
-	hero := args["hero"]
-	for _, r := range res {
+	hero := init.config.hero(args["hero"])
+	for _, r := range counts {
 		if r.Hero == hero {
 			if !r.Sameteam {
 				continue
@@ -1804,11 +2389,7 @@ func (h *hotsContext) GetCompareHero(ctx context.Context, r *http.Request) (inte
 		}
 		team[hero] = t
 	}
-	return struct {
-		SameTeam  map[string]Total
-		OtherTeam map[string]Total
-		Total     Total
-	}{
+	return heroComparison{
 		SameTeam:  sameTeam,
 		OtherTeam: otherTeam,
 		Total:     total,
@@ -1832,6 +2413,7 @@ func (h *hotsContext) GetLeaderboard(ctx context.Context, r *http.Request) (inte
 		Skill     float64
 		Total     int
 		Recent    int
+		League    string
 	}
 
 	res := struct {
@@ -1852,17 +2434,97 @@ func (h *hotsContext) GetLeaderboard(ctx context.Context, r *http.Request) (inte
 		return nil, err
 	}
 
-	g, gCtx := errgroup.WithContext(ctx)
+	init := h.getInit()
+	regionID, err := strconv.Atoi(region)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse region")
+	}
+	modeID, err := strconv.Atoi(mode)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse mode")
+	}
+	// Use the newest build with stats since the leaderboard skills are the
+	// latest skills.
+	var st Stats
+	for _, b := range init.Builds {
+		var ok bool
+		if st, ok = init.skillStats(b.ID, regionID, Mode(modeID)); ok {
+			break
+		}
+	}
 	for _, p := range res.Players {
-		p := p
-		g.Go(func() error {
-			var err error
-			p.Battletag, err = h.getBattletag(gCtx, p.Blizzid, region)
-			return err
-		})
+		if p.Rank <= init.grandmaster {
+			p.League = grandmasterLeague
+		} else {
+			p.League = init.league(st, p.Skill)
+		}
 	}
-	err := g.Wait()
-	return res, err
+
+	blizzids := make([]string, len(res.Players))
+	for i, p := range res.Players {
+		blizzids[i] = p.Blizzid
+	}
+	battletags, err := h.getBattletags(ctx, region, blizzids)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]*string, len(res.Players))
+	for i, p := range res.Players {
+		p.Battletag = battletags[p.Blizzid]
+		names[i] = &p.Battletag
+	}
+	private, err := h.anonymize(ctx, region, blizzids, names)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range res.Players {
+		if private[i] {
+			p.Blizzid = ""
+		}
+	}
+	return res, nil
+}
+
+const grandmasterLeague = "Grandmaster"
+
+// getBaseline returns the arguments that args should be compared against,
+// or false if there is nothing to compare to. An empty baseline means the
+// build before args["build"]. Otherwise baseline is either a build ID or a
+// URL-encoded set of arguments that override args, like "mode=3" to compare
+// against Hero League or "build=2.30.0&map=" for all maps of another build.
+func (h *hotsContext) getBaseline(
+	init initData, args map[string]string, baseline string,
+) (map[string]string, bool, error) {
+	argsPrev := make(map[string]string, len(args))
+	for k, v := range args {
+		argsPrev[k] = v
+	}
+	if baseline == "" {
+		if args["from"] != "" || args["to"] != "" {
+			return nil, false, nil
+		}
+		prevBuild, ok := h.getBuildBefore(init, args["build"])
+		if !ok {
+			return nil, false, nil
+		}
+		argsPrev["build"] = prevBuild
+		return argsPrev, true, nil
+	}
+	if !strings.Contains(baseline, "=") {
+		argsPrev["build"] = baseline
+		return argsPrev, true, nil
+	}
+	v, err := url.ParseQuery(baseline)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "parse baseline")
+	}
+	for key := range v {
+		if _, ok := args[key]; !ok {
+			return nil, false, errors.Errorf("unsupported baseline argument: %s", key)
+		}
+		argsPrev[key] = v.Get(key)
+	}
+	return argsPrev, true, nil
 }
 
 func (h *hotsContext) getBuildBefore(init initData, id string) (build string, ok bool) {
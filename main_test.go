@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetOptionalBuildParams(t *testing.T) {
+	init := initData{}
+	init.config = &groupConfig{Map: map[string]map[string]string{
+		"build": {"2.30.0": "1"},
+	}}
+	tests := map[string]struct {
+		args   map[string]string
+		wheres []string
+		params []interface{}
+	}{
+		"none": {
+			args:   map[string]string{},
+			wheres: []string{"blizzid = $1"},
+			params: []interface{}{"1"},
+		},
+		"to": {
+			args:   map[string]string{"to": "2018-01-02"},
+			wheres: []string{"blizzid = $1", "time < $2"},
+			params: []interface{}{"1", time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)},
+		},
+		"from and to": {
+			args:   map[string]string{"from": "2018-01-01", "to": "2018-01-02"},
+			wheres: []string{"blizzid = $1", "time >= $2", "time < $3"},
+			params: []interface{}{
+				"1",
+				time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		"build and to": {
+			args:   map[string]string{"build": "2.30.0", "to": "2018-01-02"},
+			wheres: []string{"blizzid = $1", "build = $2", "time < $3"},
+			params: []interface{}{"1", "1", time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Start with a param as the player endpoints do.
+			wheres := []string{"blizzid = $1"}
+			params := []interface{}{"1"}
+			if err := setOptionalBuildParams(init, &wheres, &params, tc.args); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(wheres, tc.wheres) {
+				t.Fatalf("expected wheres %q, got %q", tc.wheres, wheres)
+			}
+			if !reflect.DeepEqual(params, tc.params) {
+				t.Fatalf("expected params %v, got %v", tc.params, params)
+			}
+		})
+	}
+	t.Run("bad to", func(t *testing.T) {
+		var wheres []string
+		var params []interface{}
+		if err := setOptionalBuildParams(init, &wheres, &params, map[string]string{"to": "yesterday"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type mapShare struct {
+	Date  string
+	Games int
+	// Total is the number of games on all maps.
+	Total int
+	Share float64
+}
+
+// GetMapData returns hero winrates, game lengths and objective stats of a
+// map, and how its share of games changed over time.
+func (h *hotsContext) GetMapData(ctx context.Context, r *http.Request) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"herolevel":       r.FormValue("herolevel"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"skill_low":       r.FormValue("skill_low"),
+		"skill_high":      r.FormValue("skill_high"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
+	if args["map"] == "" {
+		return nil, errors.New("map required")
+	}
+	if strings.Contains(args["map"], ",") {
+		return nil, errors.New("only one map allowed")
+	}
+	interval, err := getInterval(r, "week")
+	if err != nil {
+		return nil, err
+	}
+	init := h.getInit()
+
+	/*
+		All counts are of the same population: games on the map with a
+		player in the skill bracket. Heroes additionally require the
+		herolevel, like the other winrate endpoints, but PickRates count
+		picks at all levels so they are relative to Games.
+	*/
+	var res struct {
+		Heroes    map[string]Total
+		PickRates map[string]float64
+		Games     int
+		// Lengths are game counts grouped in 5 minute blocks.
+		Lengths map[string]int
+		// Objectives are the per game averages of both teams combined, with
+		// team values as in teamStat.
+		Objectives struct {
+			MercCampCaptures   float64
+			WatchTowerCaptures float64
+			StructureDamage    float64
+		}
+		Interval string
+		Share    []mapShare
+	}
+	res.Interval = interval
+
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
+	}
+	if err := setSkillParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	// The share is relative to all maps, so filter by everything but map.
+	var shareWheres []string
+	var shareParams []interface{}
+	if err := setBuildParams(init, &shareWheres, &shareParams, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &shareWheres, &shareParams, args, "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&shareWheres, args); err != nil {
+		return nil, err
+	}
+	shareParams = append(shareParams, init.config.gamemap(args["map"]))
+	mapParam := len(shareParams)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		res.Heroes, err = h.getWinrates(ctx, init, args)
+		return errors.Wrap(err, "heroes")
+	})
+	var picks map[string]int
+	g.Go(func() error {
+		var rows []struct {
+			Hero  string
+			Count int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT hero, count(*) AS count
+			FROM players
+			WHERE %s
+			GROUP BY hero
+			`, strings.Join(wheres, " AND ")), params...); err != nil {
+			return errors.Wrap(err, "picks")
+		}
+		picks = make(map[string]int, len(rows))
+		for _, r := range rows {
+			picks[init.lookups["hero"](r.Hero)] = r.Count
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var rows []struct {
+			Counter string
+			Count   int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT counter * 60 * 5 AS counter, count(*) AS count
+			FROM (
+				SELECT DISTINCT game, round(length / 60 / 5) AS counter
+				FROM players
+				WHERE %s
+			)
+			GROUP BY counter
+			`, strings.Join(wheres, " AND ")), params...); err != nil {
+			return errors.Wrap(err, "lengths")
+		}
+		res.Lengths = make(map[string]int, len(rows))
+		for _, r := range rows {
+			res.Lengths[r.Counter] = r.Count
+			res.Games += r.Count
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var obj struct {
+			Games     int
+			Mercs     *float64
+			Towers    *float64
+			Structure *float64
+		}
+		if err := h.x.GetContext(ctx, &obj, fmt.Sprintf(`
+			SELECT
+				count(DISTINCT game) AS games,
+				sum(mercs) AS mercs,
+				sum(towers) AS towers,
+				sum(structure) AS structure
+			FROM (
+				SELECT
+					game,
+					%[2]s AS mercs,
+					%[3]s AS towers,
+					%[4]s AS structure
+				FROM players
+				WHERE game IN (SELECT game FROM players WHERE %[1]s)
+				GROUP BY game, team
+			)
+			`, strings.Join(wheres, " AND "),
+			teamStat("merc_camp_captures"),
+			teamStat("watch_tower_captures"),
+			teamStat("structure_damage"),
+		), params...); err != nil {
+			return errors.Wrap(err, "objectives")
+		}
+		if obj.Games == 0 {
+			return nil
+		}
+		avg := func(v *float64) float64 {
+			if v == nil {
+				return 0
+			}
+			return *v / float64(obj.Games)
+		}
+		res.Objectives.MercCampCaptures = avg(obj.Mercs)
+		res.Objectives.WatchTowerCaptures = avg(obj.Towers)
+		res.Objectives.StructureDamage = avg(obj.Structure)
+		return nil
+	})
+	g.Go(func() error {
+		var rows []struct {
+			Date  time.Time
+			Onmap bool
+			Count int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT date_trunc('%s', time) AS date, map = $%d AS onmap, count(*) AS count
+			FROM games
+			WHERE %s
+			GROUP BY date, onmap
+			`, interval, mapParam, strings.Join(shareWheres, " AND ")), shareParams...); err != nil {
+			return errors.Wrap(err, "share")
+		}
+		shares := make(map[string]mapShare)
+		for _, r := range rows {
+			d := r.Date.Format(dateFormat)
+			s := shares[d]
+			s.Date = d
+			s.Total += r.Count
+			if r.Onmap {
+				s.Games += r.Count
+			}
+			shares[d] = s
+		}
+		for _, s := range shares {
+			s.Share = float64(s.Games) / float64(s.Total)
+			res.Share = append(res.Share, s)
+		}
+		sort.Slice(res.Share, func(i, j int) bool {
+			return res.Share[i].Date < res.Share[j].Date
+		})
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	res.PickRates = make(map[string]float64, len(picks))
+	if res.Games > 0 {
+		for hero, n := range picks {
+			res.PickRates[hero] = float64(n) / float64(res.Games)
+		}
+	}
+	return res, nil
+}
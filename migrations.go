@@ -67,6 +67,180 @@ func mustMigrate(db *sql.DB) {
 				);
 			`,
 		},
+		{
+			ID: "4",
+			Up: `
+				CREATE TABLE IF NOT EXISTS regionskillstats (
+					build INT,
+					region INT,
+					mode INT,
+					data JSONB,
+					PRIMARY KEY (build, region, mode)
+				);
+			`,
+		},
+		{
+			ID: "5",
+			Up: `
+				CREATE TABLE IF NOT EXISTS winfactors (
+					build INT,
+					map INT,
+					mode INT,
+					data JSONB,
+					PRIMARY KEY (build, map, mode)
+				);
+			`,
+		},
+		{
+			ID: "6",
+			Up: `
+				ALTER TABLE IF EXISTS games ADD COLUMN IF NOT EXISTS leaver BOOL NOT NULL DEFAULT false;
+				ALTER TABLE IF EXISTS players ADD COLUMN IF NOT EXISTS leaver BOOL NOT NULL DEFAULT false;
+			`,
+		},
+		{
+			ID: "7",
+			Up: `
+				ALTER TABLE IF EXISTS players ADD COLUMN IF NOT EXISTS hero_games INT;
+			`,
+		},
+		{
+			ID: "8",
+			Up: `
+				CREATE TABLE IF NOT EXISTS combos (
+					build INT,
+					mode INT,
+					data JSONB,
+					PRIMARY KEY (build, mode)
+				);
+			`,
+		},
+		{
+			ID: "9",
+			Up: `
+				CREATE TABLE IF NOT EXISTS herosimilarity (
+					build INT PRIMARY KEY,
+					data JSONB
+				);
+			`,
+		},
+		{
+			ID: "10",
+			Up: `
+				CREATE TABLE IF NOT EXISTS suspicion (
+					region INT,
+					blizzid INT,
+					score FLOAT,
+					games INT,
+					early_winrate FLOAT,
+					skill_rise FLOAT,
+					damage_percentile FLOAT,
+					PRIMARY KEY (region, blizzid)
+				);
+			`,
+		},
+		{
+			ID: "11",
+			Up: `
+				CREATE TABLE IF NOT EXISTS battletags (
+					region INT,
+					blizzid INT,
+					battletag STRING COLLATE en_u_ks_level1,
+					first_seen TIMESTAMP,
+					last_seen TIMESTAMP,
+					PRIMARY KEY (region, blizzid, battletag),
+					INDEX (region, blizzid, last_seen DESC),
+					INDEX (region, battletag)
+				);
+			`,
+		},
+		{
+			ID: "12",
+			Up: `
+				ALTER TABLE IF EXISTS players ADD COLUMN IF NOT EXISTS skill_sigma FLOAT;
+			`,
+		},
+		{
+			ID: "13",
+			Up: `
+				CREATE TABLE IF NOT EXISTS coplay (
+					region INT,
+					blizzid INT,
+					other INT,
+					block INT,
+					together INT,
+					together_wins INT,
+					against INT,
+					against_wins INT,
+					last_played TIMESTAMP,
+					PRIMARY KEY (region, blizzid, other, block),
+					INDEX (block)
+				);
+			`,
+		},
+		{
+			ID: "14",
+			Up: `
+				CREATE TABLE IF NOT EXISTS accountlinks (
+					region INT,
+					blizzid INT,
+					account INT NOT NULL,
+					created TIMESTAMP DEFAULT now(),
+					PRIMARY KEY (region, blizzid),
+					UNIQUE INDEX (account, region)
+				);
+			`,
+		},
+		{
+			ID: "15",
+			Up: `
+				CREATE TABLE IF NOT EXISTS privacy (
+					region INT,
+					blizzid INT,
+					created TIMESTAMP DEFAULT now(),
+					PRIMARY KEY (region, blizzid)
+				);
+			`,
+		},
+		{
+			ID: "16",
+			Up: `
+				CREATE TABLE IF NOT EXISTS herostats (
+					build INT,
+					hero INT,
+					data JSONB,
+					PRIMARY KEY (build, hero)
+				);
+			`,
+		},
+		{
+			// Co-play edges are cumulative per pair. Rebuild with -coplay.
+			ID: "17",
+			Up: `
+				DROP TABLE IF EXISTS coplay;
+				CREATE TABLE coplay (
+					region INT,
+					blizzid INT,
+					other INT,
+					together INT,
+					together_wins INT,
+					against INT,
+					against_wins INT,
+					PRIMARY KEY (region, blizzid, other)
+				);
+				CREATE TABLE IF NOT EXISTS coplayapplied (
+					game INT PRIMARY KEY,
+					created TIMESTAMP DEFAULT now()
+				);
+			`,
+		},
+		{
+			// Existing links were never confirmed by their players.
+			ID: "18",
+			Up: `
+				ALTER TABLE accountlinks ADD COLUMN IF NOT EXISTS confirmed BOOL NOT NULL DEFAULT false;
+			`,
+		},
 	}
 
 	const migrateTable = "migrations"
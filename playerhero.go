@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type playerHeroStat struct {
+	// Player and Population are the average per game values of the player
+	// and of all players of the hero.
+	Player     float64
+	Population float64
+}
+
+type playerHeroPoint struct {
+	Date string
+	Total
+	Winrate float64
+}
+
+// GetPlayerHero returns a player's games, winrate over time, talent builds,
+// maps, matchups and score stats on one hero.
+func (h *hotsContext) GetPlayerHero(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	hero := r.FormValue("hero")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	if hero == "" {
+		return nil, errors.New("no hero parameter")
+	}
+	interval, err := getInterval(r, "week")
+	if err != nil {
+		return nil, err
+	}
+	init := h.getInit()
+	args := map[string]string{
+		"build":     r.FormValue("build"),
+		"from":      r.FormValue("from"),
+		"to":        r.FormValue("to"),
+		"hero":      hero,
+		"region":    region,
+		"blizzid":   blizzid,
+		"herolevel": "0",
+	}
+	// Population wheres are everything but the player.
+	var popWheres []string
+	var popParams []interface{}
+	if err := setBuildParams(init, &popWheres, &popParams, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &popWheres, &popParams, args, "hero", "region"); err != nil {
+		return nil, err
+	}
+	wheres := append([]string(nil), popWheres...)
+	params := append([]interface{}(nil), popParams...)
+	if err := setArgParams(init, &wheres, &params, args, "blizzid"); err != nil {
+		return nil, err
+	}
+	where := strings.Join(wheres, " AND ")
+
+	type game struct {
+		Game   int64
+		Time   time.Time
+		Map    string
+		Mode   Mode
+		Build  string
+		Length int
+		Winner bool
+	}
+	var res struct {
+		Battletag string
+		Hero      string
+		Total
+		Games         []game
+		Interval      string
+		Winrates      []playerHeroPoint
+		Maps          map[string]Total
+		Talents       map[int]map[string]Total
+		PopularBuilds []build
+		WinningBuilds []build
+		Same          map[string]Total
+		Opposing      map[string]Total
+		Stats         map[string]playerHeroStat
+	}
+	res.Hero = hero
+	res.Interval = interval
+	res.Maps = make(map[string]Total)
+	res.Same = make(map[string]Total)
+	res.Opposing = make(map[string]Total)
+	res.Stats = make(map[string]playerHeroStat)
+	count := func(winner bool, m map[string]Total, name string) {
+		v := m[name]
+		if winner {
+			v.Wins++
+		} else {
+			v.Losses++
+		}
+		m[name] = v
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		res.Battletag, err = h.getBattletag(gCtx, blizzid, region)
+		return err
+	})
+	g.Go(func() error {
+		if err := h.x.SelectContext(gCtx, &res.Games, fmt.Sprintf(`
+			SELECT game, time, map, mode, build, length, winner
+			FROM players
+			WHERE %s
+			ORDER BY time DESC
+			`, where), params...); err != nil {
+			return errors.Wrap(err, "games")
+		}
+		points := make(map[string]*playerHeroPoint)
+		for i := range res.Games {
+			g := &res.Games[i]
+			g.Map = init.lookups["map"](g.Map)
+			g.Build = init.lookups["build"](g.Build)
+			if g.Winner {
+				res.Wins++
+			} else {
+				res.Losses++
+			}
+			count(g.Winner, res.Maps, g.Map)
+			date := g.Time.UTC().Truncate(time.Hour * 24)
+			if interval == "week" {
+				// Weeks start on Monday, the same as date_trunc.
+				date = date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+			}
+			d := date.Format(dateFormat)
+			p := points[d]
+			if p == nil {
+				p = &playerHeroPoint{Date: d}
+				points[d] = p
+			}
+			if g.Winner {
+				p.Wins++
+			} else {
+				p.Losses++
+			}
+		}
+		for _, p := range points {
+			p.Winrate = float64(p.Wins) / float64(p.Wins+p.Losses)
+			res.Winrates = append(res.Winrates, *p)
+		}
+		sort.Slice(res.Winrates, func(i, j int) bool {
+			return res.Winrates[i].Date < res.Winrates[j].Date
+		})
+		return nil
+	})
+	g.Go(func() error {
+		var err error
+		res.Talents, res.PopularBuilds, res.WinningBuilds, err = h.getBuildWinrates(gCtx, init, args)
+		return errors.Wrap(err, "builds")
+	})
+	g.Go(func() error {
+		var rows []struct {
+			Hero   string
+			Winner bool
+			Same   bool
+		}
+		if err := h.x.SelectContext(gCtx, &rows, fmt.Sprintf(`
+			SELECT o.hero, p.winner, o.team = p.team AS same
+			FROM
+				(
+					SELECT game, blizzid, team, winner
+					FROM players
+					WHERE %s
+				) AS p
+				JOIN players AS o ON o.game = p.game AND o.blizzid != p.blizzid
+			`, where), params...); err != nil {
+			return errors.Wrap(err, "matchups")
+		}
+		for _, r := range rows {
+			m := res.Opposing
+			if r.Same {
+				m = res.Same
+			}
+			count(r.Winner, m, init.lookups["hero"](r.Hero))
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var avgs []string
+		for _, s := range similarityStats {
+			avgs = append(avgs, fmt.Sprintf("COALESCE(avg((data->>'%s')::FLOAT), 0)", s))
+		}
+		query := fmt.Sprintf(`SELECT %s FROM players WHERE %%s`, strings.Join(avgs, ", "))
+		player := make([]float64, len(similarityStats))
+		pop := make([]float64, len(similarityStats))
+		for _, q := range []struct {
+			dest   []float64
+			where  []string
+			params []interface{}
+		}{
+			{player, wheres, params},
+			{pop, popWheres, popParams},
+		} {
+			dest := make([]interface{}, len(q.dest))
+			for i := range q.dest {
+				dest[i] = &q.dest[i]
+			}
+			if err := h.db.QueryRowContext(gCtx, fmt.Sprintf(query, strings.Join(q.where, " AND ")), q.params...).Scan(dest...); err != nil {
+				return errors.Wrap(err, "stats")
+			}
+		}
+		for i, s := range similarityStats {
+			res.Stats[s] = playerHeroStat{Player: player[i], Population: pop[i]}
+		}
+		return nil
+	})
+	err = g.Wait()
+	return res, err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// privacySaltKey is the config key of the secret used to make pseudonyms.
+// Without a secret pseudonyms could be reversed by hashing all blizzids.
+const privacySaltKey = "privacy-salt"
+
+// privateResult is returned instead of the data of a private player.
+type privateResult struct {
+	Private bool
+}
+
+func (h *hotsContext) getPrivacySalt(ctx context.Context) ([]byte, error) {
+	h.mu.RLock()
+	salt := h.mu.privacySalt
+	h.mu.RUnlock()
+	if salt != nil {
+		return salt, nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "rand")
+	}
+	// The first server to get here sets the salt.
+	if _, err := h.db.ExecContext(ctx, `
+		INSERT INTO config (key, s) VALUES ($1, $2)
+		ON CONFLICT (key) DO NOTHING
+		`, privacySaltKey, hex.EncodeToString(b)); err != nil {
+		return nil, errors.Wrap(err, "set salt")
+	}
+	var s string
+	if err := h.x.GetContext(ctx, &s, `SELECT s FROM config WHERE key = $1`, privacySaltKey); err != nil {
+		return nil, errors.Wrap(err, "get salt")
+	}
+	salt, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode salt")
+	}
+	h.mu.Lock()
+	h.mu.privacySalt = salt
+	h.mu.Unlock()
+	return salt, nil
+}
+
+// pseudonym returns the stable replacement battletag of a private player.
+func pseudonym(salt []byte, p regionPlayer) string {
+	mac := hmac.New(sha256.New, salt)
+	fmt.Fprintf(mac, "%d:%d", p.region, p.blizzid)
+	return "Anonymous#" + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// getPseudonyms returns the pseudonyms of the players that are private.
+// Players that are not private are not in the result.
+func (h *hotsContext) getPseudonyms(ctx context.Context, players []regionPlayer) (map[regionPlayer]string, error) {
+	res := make(map[regionPlayer]string)
+	if len(players) == 0 {
+		return res, nil
+	}
+	seen := make(map[regionPlayer]bool, len(players))
+	var unique []regionPlayer
+	for _, p := range players {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	var rows []struct {
+		Region  int
+		Blizzid int64
+	}
+	// Query in batches to stay under the placeholder limit.
+	const batch = 1000
+	for len(unique) > 0 {
+		next := unique
+		if len(next) > batch {
+			next = next[:batch]
+		}
+		unique = unique[len(next):]
+		var tuples []string
+		var args []interface{}
+		for _, p := range next {
+			tuples = append(tuples, makeValues(2, len(args)+1))
+			args = append(args, p.region, p.blizzid)
+		}
+		var found []struct {
+			Region  int
+			Blizzid int64
+		}
+		if err := h.x.SelectContext(ctx, &found, fmt.Sprintf(`
+			SELECT region, blizzid
+			FROM privacy
+			WHERE (region, blizzid) IN (%s)
+			`, strings.Join(tuples, ", ")), args...); err != nil {
+			return nil, errors.Wrap(err, "get private")
+		}
+		rows = append(rows, found...)
+	}
+	if len(rows) == 0 {
+		return res, nil
+	}
+	salt, err := h.getPrivacySalt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		p := regionPlayer{r.Region, r.Blizzid}
+		res[p] = pseudonym(salt, p)
+	}
+	return res, nil
+}
+
+// parseRegionPlayer parses a region and blizzid.
+func parseRegionPlayer(region, blizzid string) (regionPlayer, error) {
+	r, err := strconv.Atoi(region)
+	if err != nil {
+		return regionPlayer{}, errors.Wrap(err, "parse region")
+	}
+	b, err := strconv.ParseInt(blizzid, 10, 64)
+	if err != nil {
+		return regionPlayer{}, errors.Wrap(err, "parse blizzid")
+	}
+	return regionPlayer{r, b}, nil
+}
+
+// anonymize replaces the battletags of private players in one region. It
+// returns whether the player at each index is private; their blizzids
+// should then be removed by the caller.
+func (h *hotsContext) anonymize(ctx context.Context, region string, blizzids []string, battletags []*string) ([]bool, error) {
+	players := make([]regionPlayer, len(blizzids))
+	for i, b := range blizzids {
+		var err error
+		players[i], err = parseRegionPlayer(region, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	names, err := h.getPseudonyms(ctx, players)
+	if err != nil {
+		return nil, err
+	}
+	private := make([]bool, len(players))
+	for i, p := range players {
+		if name, ok := names[p]; ok {
+			*battletags[i] = name
+			private[i] = true
+		}
+	}
+	return private, nil
+}
+
+// isPrivate returns whether a player has opted out.
+func (h *hotsContext) isPrivate(ctx context.Context, region, blizzid string) (bool, error) {
+	p, err := parseRegionPlayer(region, blizzid)
+	if err != nil {
+		return false, err
+	}
+	names, err := h.getPseudonyms(ctx, []regionPlayer{p})
+	if err != nil {
+		return false, err
+	}
+	_, ok := names[p]
+	return ok, nil
+}
+
+// respectPrivacy wraps a player endpoint to return a privateResult if a
+// requested player (region and blizzid, or region1 and blizzid1, and so on)
+// is private.
+func (h *hotsContext) respectPrivacy(
+	f func(context.Context, *http.Request) (interface{}, error),
+) func(context.Context, *http.Request) (interface{}, error) {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		for _, suffix := range []string{"", "1", "2"} {
+			region, blizzid := r.FormValue("region"+suffix), r.FormValue("blizzid"+suffix)
+			if region == "" || blizzid == "" {
+				continue
+			}
+			private, err := h.isPrivate(ctx, region, blizzid)
+			if err != nil {
+				return nil, err
+			}
+			if private {
+				return privateResult{Private: true}, nil
+			}
+		}
+		return f(ctx, r)
+	}
+}
+
+/*
+SetPrivacy opts a player (region and blizzid) out of or back into public
+data if private is true or false. It must be a POST. Private players have
+their battletag replaced by a stable pseudonym and their blizzid removed in
+responses, their profile endpoints return a privateResult, and they are not
+returned by player search. Anyone could call it for any player, so until
+players can sign in with Battle.net it is only served on -adminaddr, where an
+operator sets it for players that asked.
+*/
+func (h *hotsContext) SetPrivacy(ctx context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("must POST")
+	}
+	p, err := parseRegionPlayer(r.FormValue("region"), r.FormValue("blizzid"))
+	if err != nil {
+		return nil, err
+	}
+	private, err := strconv.ParseBool(r.FormValue("private"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private")
+	}
+	if err := retry(func() error {
+		var err error
+		if private {
+			_, err = h.db.ExecContext(ctx, `UPSERT INTO privacy (region, blizzid) VALUES ($1, $2)`, p.region, p.blizzid)
+		} else {
+			_, err = h.db.ExecContext(ctx, `DELETE FROM privacy WHERE region = $1 AND blizzid = $2`, p.region, p.blizzid)
+		}
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "set privacy")
+	}
+	if err := h.clearPlayerCache(ctx, p); err != nil {
+		return nil, err
+	}
+	return privateResult{Private: private}, nil
+}
+
+// clearPlayerCache removes cached responses that may contain a player:
+// leaderboards and any request with its blizzid.
+func (h *hotsContext) clearPlayerCache(ctx context.Context, p regionPlayer) error {
+	const leaderboard = "/api/get-leaderboard"
+	param := fmt.Sprintf("blizzid=%d", p.blizzid)
+	h.mu.Lock()
+	for url := range h.mu.cache {
+		if strings.HasPrefix(url, leaderboard) || strings.HasSuffix(url, param) || strings.Contains(url, param+"&") {
+			delete(h.mu.cache, url)
+		}
+	}
+	h.mu.Unlock()
+	return retry(func() error {
+		_, err := h.db.ExecContext(ctx, `
+			DELETE FROM cache
+			WHERE id LIKE $1 OR id LIKE $2 OR id LIKE $3
+			`, leaderboard+"%", "%"+param, "%"+param+"&%")
+		return errors.Wrap(err, "clear cache")
+	})
+}
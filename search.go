@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// playerSearchLimit is the maximum number of players returned by a search.
+const playerSearchLimit = 10
+
+type playerIndexEntry struct {
+	region   int
+	blizzid  int64
+	name     string
+	lastSeen time.Time
+}
+
+/*
+playerIndex is an in-memory trigram index of all battletags. Names are
+indexed without their #1234 suffix, lowercased and padded with two spaces
+at the start, so the trigrams of an unpadded query at the start of a name
+match prefixes. Full lowercased battletags are indexed separately for exact
+matches.
+*/
+type playerIndex struct {
+	// version is the next-update config value the index was built at.
+	version  int
+	entries  []playerIndexEntry
+	trigrams map[string][]int32
+	exact    map[string][]int32
+}
+
+func nameTrigrams(s string, pad bool) []string {
+	if pad {
+		s = "  " + s + " "
+	} else {
+		s = "  " + s
+	}
+	r := []rune(s)
+	seen := make(map[string]bool, len(r))
+	var res []string
+	for i := 0; i+3 <= len(r); i++ {
+		t := string(r[i : i+3])
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// battletagName returns the lowercased name part of a battletag.
+func battletagName(battletag string) string {
+	if i := strings.LastIndexByte(battletag, '#'); i >= 0 {
+		battletag = battletag[:i]
+	}
+	return strings.ToLower(battletag)
+}
+
+func newPlayerIndex(version int, entries []playerIndexEntry) *playerIndex {
+	idx := &playerIndex{
+		version:  version,
+		entries:  entries,
+		trigrams: make(map[string][]int32),
+		exact:    make(map[string][]int32),
+	}
+	for i, e := range entries {
+		idx.exact[strings.ToLower(e.name)] = append(idx.exact[strings.ToLower(e.name)], int32(i))
+		for _, t := range nameTrigrams(battletagName(e.name), true) {
+			idx.trigrams[t] = append(idx.trigrams[t], int32(i))
+		}
+	}
+	return idx
+}
+
+// maxTypos returns the number of edits allowed for a query.
+func maxTypos(q string) int {
+	switch n := utf8.RuneCountInString(q); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the optimal string alignment distance of a and b.
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			v := d[i-1][j-1] + cost
+			if d[i-1][j]+1 < v {
+				v = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < v {
+				v = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < v {
+				v = d[i-2][j-2] + 1
+			}
+			d[i][j] = v
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+type playerMatch struct {
+	entry *playerIndexEntry
+	// rank is 0 for an exact name, 1 for a prefix, then 2 plus the number
+	// of typos.
+	rank int
+}
+
+// search returns the best matches of q in region, or all regions if region
+// is 0. A query with a # only matches full battletags exactly. Otherwise
+// names are matched by prefix with typo tolerance. Matches are ranked by
+// closeness then most recently seen. Each player is returned once.
+func (idx *playerIndex) search(q string, region int) []playerMatch {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return nil
+	}
+	var matches []playerMatch
+	if strings.Contains(q, "#") {
+		for _, i := range idx.exact[q] {
+			matches = append(matches, playerMatch{entry: &idx.entries[i]})
+		}
+	} else {
+		typos := maxTypos(q)
+		trigrams := nameTrigrams(q, false)
+		// Each edit changes at most three trigrams.
+		need := len(trigrams) - 3*typos
+		if need < 1 {
+			need = 1
+		}
+		counts := make(map[int32]int)
+		for _, t := range trigrams {
+			for _, i := range idx.trigrams[t] {
+				counts[i]++
+			}
+		}
+		qr := []rune(q)
+		for i, c := range counts {
+			if c < need {
+				continue
+			}
+			e := &idx.entries[i]
+			if region != 0 && e.region != region {
+				continue
+			}
+			name := battletagName(e.name)
+			var rank int
+			switch {
+			case name == q:
+				rank = 0
+			case strings.HasPrefix(name, q):
+				rank = 1
+			default:
+				nr := []rune(name)
+				dist := editDistance(qr, nr)
+				// Allow typos in a prefix: compare against the name cut
+				// to the query length, give or take an edit.
+				for _, n := range []int{len(qr) - 1, len(qr), len(qr) + 1} {
+					if n > 0 && n < len(nr) {
+						if d := editDistance(qr, nr[:n]); d < dist {
+							dist = d
+						}
+					}
+				}
+				if dist > typos {
+					continue
+				}
+				rank = 2 + dist
+			}
+			matches = append(matches, playerMatch{entry: e, rank: rank})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].entry.lastSeen.After(matches[j].entry.lastSeen)
+	})
+	seen := make(map[regionPlayer]bool)
+	var res []playerMatch
+	for _, m := range matches {
+		rp := regionPlayer{m.entry.region, m.entry.blizzid}
+		if seen[rp] {
+			continue
+		}
+		seen[rp] = true
+		res = append(res, m)
+		if len(res) >= playerSearchLimit {
+			break
+		}
+	}
+	return res
+}
+
+// updatePlayerIndex rebuilds the player search index if new games have
+// been ingested since it was last built.
+func (h *hotsContext) updatePlayerIndex(ctx context.Context) error {
+	var version int
+	if err := h.x.GetContext(ctx, &version, `SELECT i FROM config WHERE key = $1`, nextUpdateKey); err != nil {
+		return errors.Wrap(err, "get version")
+	}
+	h.mu.RLock()
+	current := h.mu.players
+	h.mu.RUnlock()
+	if current != nil && current.version == version {
+		return nil
+	}
+	start := time.Now()
+	rows, err := h.db.QueryContext(ctx, `SELECT region, blizzid, battletag, last_seen FROM battletags`)
+	if err != nil {
+		return errors.Wrap(err, "fetch battletags")
+	}
+	defer rows.Close()
+	var entries []playerIndexEntry
+	for rows.Next() {
+		var e playerIndexEntry
+		if err := rows.Scan(&e.region, &e.blizzid, &e.name, &e.lastSeen); err != nil {
+			return errors.Wrap(err, "scan")
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "rows")
+	}
+	idx := newPlayerIndex(version, entries)
+	h.mu.Lock()
+	h.mu.players = idx
+	h.mu.Unlock()
+	fmt.Println("built player index of", len(entries), "names in", time.Since(start))
+	return nil
+}
+
+func (h *hotsContext) searchPlayers(ctx context.Context, q string, region int) ([]playerMatch, error) {
+	h.mu.RLock()
+	idx := h.mu.players
+	h.mu.RUnlock()
+	if idx == nil {
+		if err := h.updatePlayerIndex(ctx); err != nil {
+			return nil, err
+		}
+		h.mu.RLock()
+		idx = h.mu.players
+		h.mu.RUnlock()
+	}
+	return idx.search(q, region), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := map[[2]string]int{
+		{"", ""}:               0,
+		{"abc", ""}:            3,
+		{"", "abc"}:            3,
+		{"abc", "abc"}:         0,
+		{"kitten", "sitting"}:  3,
+		{"ab", "ba"}:           1,
+		{"falstad", "falstda"}: 1,
+		// Optimal string alignment doesn't edit a substring twice.
+		{"ca", "abc"}:      3,
+		{"héllo", "hello"}: 1,
+	}
+	for tc, expect := range tests {
+		t.Run(tc[0]+","+tc[1], func(t *testing.T) {
+			res := editDistance([]rune(tc[0]), []rune(tc[1]))
+			if res != expect {
+				t.Fatalf("expected %v, got %v", expect, res)
+			}
+		})
+	}
+}
+
+func TestNameTrigrams(t *testing.T) {
+	tests := []struct {
+		s      string
+		pad    bool
+		expect []string
+	}{
+		{"ab", false, []string{"  a", " ab"}},
+		{"ab", true, []string{"  a", " ab", "ab "}},
+		{"aaaa", false, []string{"  a", " aa", "aaa"}},
+		{"", true, []string{"   "}},
+		{"é", false, []string{"  é"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.s, func(t *testing.T) {
+			res := nameTrigrams(tc.s, tc.pad)
+			if !reflect.DeepEqual(res, tc.expect) {
+				t.Fatalf("expected %q, got %q", tc.expect, res)
+			}
+		})
+	}
+}
+
+func TestPlayerIndexSearch(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2018, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	idx := newPlayerIndex(0, []playerIndexEntry{
+		{region: 1, blizzid: 1, name: "Falstad#1234", lastSeen: day(3)},
+		{region: 1, blizzid: 2, name: "Falcon#2222", lastSeen: day(5)},
+		{region: 2, blizzid: 3, name: "Falstad#9999", lastSeen: day(1)},
+		{region: 1, blizzid: 4, name: "Fastad#1111", lastSeen: day(2)},
+		// An older name of player 1.
+		{region: 1, blizzid: 1, name: "Falstaad#1234", lastSeen: day(1)},
+		{region: 1, blizzid: 5, name: "Abc#1", lastSeen: day(1)},
+	})
+	tests := []struct {
+		q      string
+		region int
+		// expect are the blizzids of the matches in order.
+		expect []int64
+	}{
+		// Exact names, then typos.
+		{"falstad", 0, []int64{1, 3, 4}},
+		{"falstad", 2, []int64{3}},
+		// Prefixes are ordered by last seen.
+		{"fal", 0, []int64{2, 1, 3}},
+		// Short queries don't allow typos.
+		{"fsl", 0, nil},
+		{"falstda", 0, []int64{1, 3}},
+		{"falsatd", 1, []int64{1}},
+		{"Falstad#9999", 0, []int64{3}},
+		{" FALSTAD#1234 ", 0, []int64{1}},
+		{"falstad#1", 0, nil},
+		{"abc", 0, []int64{5}},
+		{"xyz", 0, nil},
+		{"", 0, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.q, func(t *testing.T) {
+			var res []int64
+			for _, m := range idx.search(tc.q, tc.region) {
+				res = append(res, m.entry.blizzid)
+			}
+			if !reflect.DeepEqual(res, tc.expect) {
+				t.Fatalf("expected %v, got %v", tc.expect, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// similarityStats are the per minute score stats of the hero profiles.
+var similarityStats = []string{
+	"kills",
+	"assists",
+	"deaths",
+	"hero_damage",
+	"siege_damage",
+	"structure_damage",
+	"minion_damage",
+	"healing",
+	"self_healing",
+	"damage_taken",
+	"time_cc_enemy_heroes",
+	"experience_contribution",
+	"merc_camp_captures",
+}
+
+const (
+	// similarityMinGames is the minimum number of games a hero must have
+	// been played in a build to be included.
+	similarityMinGames = 200
+	// similarityTop is the number of similar heroes stored per hero.
+	similarityTop = 10
+	// similarityTalentMinGames is the minimum number of games a talent
+	// needs to count towards a tier's winrate spread.
+	similarityTalentMinGames = 50
+)
+
+type similarHero struct {
+	Hero       string
+	Similarity float64
+}
+
+type heroPosition struct {
+	Role    string
+	Cluster int
+	// X and Y are the first two principal components of the embedding.
+	X, Y    float64
+	Similar []similarHero
+}
+
+type heroCluster struct {
+	Heroes []string
+	// Roles counts the heroData roles of the heroes.
+	Roles map[string]int
+}
+
+type heroSimilarity struct {
+	Heroes   map[string]*heroPosition
+	Clusters []heroCluster
+}
+
+/*
+heroSimilarity computes hero embeddings for the newest builds and stores
+similar heroes and a clustered layout in the herosimilarity table. An
+embedding is made of four equally weighted blocks:
+
+ 1. Ally lift: how much more often each other hero is on the same team.
+ 2. Enemy lift: the same for the other team.
+ 3. Talent tiers: per tier the pick share of the most popular talent and the
+    spread of talent winrates.
+ 4. Score profile: per minute score stats.
+
+Each feature is standardized across heroes. Heroes are clustered with
+k-means where k is the number of roles in heroData.
+*/
+func (h *hotsContext) heroSimilarity() error {
+	ctx := context.Background()
+	if err := h.updateInit(ctx); err != nil {
+		return err
+	}
+	init := h.getInit()
+	for _, b := range init.recentBuilds() {
+		start := time.Now()
+		patch := init.config.build(b.ID)
+		emb, err := h.heroEmbeddings(ctx, patch)
+		if err != nil {
+			return errors.Wrapf(err, "embeddings: %s", b.ID)
+		}
+		if len(emb) < 2 {
+			continue
+		}
+		res := layoutHeroes(emb, init.lookups["hero"])
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if err := retry(func() error {
+			_, err := h.db.Exec(`UPSERT INTO herosimilarity (build, data) VALUES ($1, $2)`, patch, data)
+			return err
+		}); err != nil {
+			return errors.Wrap(err, "upsert herosimilarity")
+		}
+		fmt.Println("hero similarity", b.ID, "took", time.Since(start))
+	}
+	return nil
+}
+
+// heroEmbeddings returns the standardized embeddings of heroes in a build,
+// keyed by hero ID.
+func (h *hotsContext) heroEmbeddings(ctx context.Context, patch string) (map[string][]float64, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT game, team, hero, winner, talents
+		FROM players
+		WHERE build = $1 AND NOT leaver
+		`, patch)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch players")
+	}
+	type team struct {
+		game int64
+		team int
+	}
+	type tierTalent struct {
+		tier   int
+		talent string
+	}
+	teams := make(map[team][]string)
+	games := make(map[string]int)
+	talents := make(map[string]map[tierTalent]Total)
+	for rows.Next() {
+		var t team
+		var hero string
+		var talentArr sql.NullString
+		var winner bool
+		if err := rows.Scan(&t.game, &t.team, &hero, &winner, &talentArr); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "scan")
+		}
+		teams[t] = append(teams[t], hero)
+		games[hero]++
+		if talents[hero] == nil {
+			talents[hero] = make(map[tierTalent]Total)
+		}
+		s := strings.Trim(talentArr.String, "{}")
+		if s == "" {
+			continue
+		}
+		for tier, talent := range strings.Split(s, ",") {
+			k := tierTalent{tier, talent}
+			tt := talents[hero][k]
+			if winner {
+				tt.Wins++
+			} else {
+				tt.Losses++
+			}
+			talents[hero][k] = tt
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+
+	var heroes []string
+	index := make(map[string]int)
+	for hero, n := range games {
+		if n >= similarityMinGames {
+			heroes = append(heroes, hero)
+		}
+	}
+	sort.Strings(heroes)
+	for i, hero := range heroes {
+		index[hero] = i
+	}
+	nh := len(heroes)
+
+	// Co-occurrence. allies[i][j] counts teams with both i and j,
+	// enemies[i][j] counts games with i and j on opposing teams.
+	allies := make([][]float64, nh)
+	enemies := make([][]float64, nh)
+	for i := range heroes {
+		allies[i] = make([]float64, nh)
+		enemies[i] = make([]float64, nh)
+	}
+	for t, members := range teams {
+		other := teams[team{t.game, 1 - t.team}]
+		for _, a := range members {
+			i, ok := index[a]
+			if !ok {
+				continue
+			}
+			for _, b := range members {
+				if j, ok := index[b]; ok && i != j {
+					allies[i][j]++
+				}
+			}
+			for _, b := range other {
+				if j, ok := index[b]; ok {
+					enemies[i][j]++
+				}
+			}
+		}
+	}
+	totalPicks := 0
+	for _, hero := range heroes {
+		totalPicks += games[hero]
+	}
+	// lift is the observed count of j with i divided by the expected count
+	// if j were picked independently: games of i times 5 slots times the
+	// pick share of j.
+	lift := func(counts []float64, i int) []float64 {
+		out := make([]float64, nh)
+		for j, c := range counts {
+			share := float64(games[heroes[j]]) / float64(totalPicks)
+			if expected := float64(games[heroes[i]]) * 5 * share; expected > 0 {
+				out[j] = c / expected
+			}
+		}
+		return out
+	}
+
+	var scores map[string][]float64
+	{
+		var sums []string
+		for _, s := range similarityStats {
+			sums = append(sums, fmt.Sprintf("COALESCE(sum((data->>'%s')::INT), 0)::FLOAT", s))
+		}
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT hero, sum(length)::FLOAT, %s
+			FROM players
+			WHERE build = $1 AND NOT leaver
+			GROUP BY hero
+			`, strings.Join(sums, ", ")), patch)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetch scores")
+		}
+		scores = make(map[string][]float64)
+		for rows.Next() {
+			var hero string
+			var length float64
+			stats := make([]float64, len(similarityStats))
+			dest := []interface{}{&hero, &length}
+			for i := range stats {
+				dest = append(dest, &stats[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, errors.Wrap(err, "scan scores")
+			}
+			if length > 0 {
+				for i := range stats {
+					stats[i] *= 60 / length
+				}
+			}
+			scores[hero] = stats
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrap(err, "score rows")
+		}
+	}
+
+	tierFeatures := func(hero string) []float64 {
+		tiers := len(Hero{}.Talents)
+		out := make([]float64, 2*tiers)
+		for tier := 0; tier < tiers; tier++ {
+			var total, top int
+			low, high := 1.0, 0.0
+			for k, t := range talents[hero] {
+				if k.tier != tier {
+					continue
+				}
+				n := t.Wins + t.Losses
+				total += n
+				if n > top {
+					top = n
+				}
+				if n >= similarityTalentMinGames {
+					wr := float64(t.Wins) / float64(n)
+					low = math.Min(low, wr)
+					high = math.Max(high, wr)
+				}
+			}
+			if total > 0 {
+				out[tier*2] = float64(top) / float64(total)
+			}
+			if high > low {
+				out[tier*2+1] = high - low
+			}
+		}
+		return out
+	}
+
+	blocks := make([][][]float64, 4)
+	for i, hero := range heroes {
+		blocks[0] = append(blocks[0], lift(allies[i], i))
+		blocks[1] = append(blocks[1], lift(enemies[i], i))
+		blocks[2] = append(blocks[2], tierFeatures(hero))
+		s := scores[hero]
+		if s == nil {
+			s = make([]float64, len(similarityStats))
+		}
+		blocks[3] = append(blocks[3], s)
+	}
+	emb := make(map[string][]float64, nh)
+	for _, block := range blocks {
+		standardize(block)
+		for i, hero := range heroes {
+			emb[hero] = append(emb[hero], block[i]...)
+		}
+	}
+	return emb, nil
+}
+
+// standardize scales each column of rows to mean 0 and standard deviation
+// 1, then scales the whole block so each block contributes the same
+// expected squared length regardless of its number of columns.
+func standardize(rows [][]float64) {
+	if len(rows) == 0 {
+		return
+	}
+	cols := len(rows[0])
+	weight := 1 / math.Sqrt(float64(cols))
+	n := float64(len(rows))
+	for c := 0; c < cols; c++ {
+		var sum, sumSq float64
+		for _, r := range rows {
+			sum += r[c]
+			sumSq += r[c] * r[c]
+		}
+		mean := sum / n
+		sd := math.Sqrt(sumSq/n - mean*mean)
+		for _, r := range rows {
+			if sd > 0 {
+				r[c] = (r[c] - mean) / sd * weight
+			} else {
+				r[c] = 0
+			}
+		}
+	}
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// layoutHeroes finds similar heroes, clusters and 2D positions of the
+// embeddings.
+func layoutHeroes(emb map[string][]float64, heroName func(string) string) heroSimilarity {
+	var ids []string
+	for id := range emb {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	vecs := make([][]float64, len(ids))
+	for i, id := range ids {
+		vecs[i] = emb[id]
+	}
+	roles := make(map[string]string)
+	roleSet := make(map[string]bool)
+	for _, hd := range heroData {
+		roles[hd.Name] = hd.Role
+		roleSet[hd.Role] = true
+	}
+
+	res := heroSimilarity{Heroes: make(map[string]*heroPosition)}
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = heroName(id)
+	}
+	for i := range ids {
+		p := &heroPosition{Role: roles[names[i]]}
+		for j := range ids {
+			if i != j {
+				p.Similar = append(p.Similar, similarHero{names[j], cosine(vecs[i], vecs[j])})
+			}
+		}
+		sort.Slice(p.Similar, func(a, b int) bool {
+			return p.Similar[a].Similarity > p.Similar[b].Similarity
+		})
+		if len(p.Similar) > similarityTop {
+			p.Similar = p.Similar[:similarityTop]
+		}
+		res.Heroes[names[i]] = p
+	}
+
+	k := len(roleSet)
+	if k > len(ids) {
+		k = len(ids)
+	}
+	assign := kmeans(vecs, k)
+	res.Clusters = make([]heroCluster, k)
+	for i, c := range assign {
+		res.Heroes[names[i]].Cluster = c
+		cl := &res.Clusters[c]
+		cl.Heroes = append(cl.Heroes, names[i])
+		if cl.Roles == nil {
+			cl.Roles = make(map[string]int)
+		}
+		cl.Roles[res.Heroes[names[i]].Role]++
+	}
+
+	for i, xy := range principalComponents(vecs, 2) {
+		res.Heroes[names[i]].X = xy[0]
+		res.Heroes[names[i]].Y = xy[1]
+	}
+	return res
+}
+
+// kmeans returns the cluster of each vector. Centers are initialized
+// deterministically by farthest point selection starting at the first
+// vector.
+func kmeans(vecs [][]float64, k int) []int {
+	const iterations = 50
+	dist := func(a, b []float64) float64 {
+		var d float64
+		for i := range a {
+			d += (a[i] - b[i]) * (a[i] - b[i])
+		}
+		return d
+	}
+	centers := [][]float64{append([]float64(nil), vecs[0]...)}
+	for len(centers) < k {
+		best, bestDist := 0, -1.0
+		for i, v := range vecs {
+			d := math.Inf(1)
+			for _, c := range centers {
+				d = math.Min(d, dist(v, c))
+			}
+			if d > bestDist {
+				best, bestDist = i, d
+			}
+		}
+		centers = append(centers, append([]float64(nil), vecs[best]...))
+	}
+	assign := make([]int, len(vecs))
+	for iter := 0; iter < iterations; iter++ {
+		changed := false
+		for i, v := range vecs {
+			best := 0
+			for c := range centers {
+				if dist(v, centers[c]) < dist(v, centers[best]) {
+					best = c
+				}
+			}
+			if assign[i] != best || iter == 0 {
+				changed = true
+			}
+			assign[i] = best
+		}
+		if !changed {
+			break
+		}
+		counts := make([]int, k)
+		for c := range centers {
+			for j := range centers[c] {
+				centers[c][j] = 0
+			}
+		}
+		for i, v := range vecs {
+			counts[assign[i]]++
+			for j := range v {
+				centers[assign[i]][j] += v[j]
+			}
+		}
+		for c := range centers {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range centers[c] {
+				centers[c][j] /= float64(counts[c])
+			}
+		}
+	}
+	return assign
+}
+
+// principalComponents returns the projections of vecs onto their first n
+// principal components, found by power iteration with deflation.
+func principalComponents(vecs [][]float64, n int) [][]float64 {
+	const iterations = 100
+	dims := len(vecs[0])
+	centered := make([][]float64, len(vecs))
+	mean := make([]float64, dims)
+	for _, v := range vecs {
+		for j := range v {
+			mean[j] += v[j] / float64(len(vecs))
+		}
+	}
+	for i, v := range vecs {
+		centered[i] = make([]float64, dims)
+		for j := range v {
+			centered[i][j] = v[j] - mean[j]
+		}
+	}
+	res := make([][]float64, len(vecs))
+	for i := range res {
+		res[i] = make([]float64, n)
+	}
+	for c := 0; c < n; c++ {
+		comp := make([]float64, dims)
+		for j := range comp {
+			comp[j] = 1 / math.Sqrt(float64(dims+j))
+		}
+		for iter := 0; iter < iterations; iter++ {
+			// comp = Xᵀ X comp, normalized.
+			next := make([]float64, dims)
+			for _, v := range centered {
+				var p float64
+				for j := range v {
+					p += v[j] * comp[j]
+				}
+				for j := range v {
+					next[j] += p * v[j]
+				}
+			}
+			var norm float64
+			for _, x := range next {
+				norm += x * x
+			}
+			norm = math.Sqrt(norm)
+			if norm == 0 {
+				break
+			}
+			for j := range next {
+				comp[j] = next[j] / norm
+			}
+		}
+		// Project and deflate.
+		for i, v := range centered {
+			var p float64
+			for j := range v {
+				p += v[j] * comp[j]
+			}
+			res[i][c] = p
+			for j := range v {
+				v[j] -= p * comp[j]
+			}
+		}
+	}
+	return res
+}
+
+// GetHeroSimilarity returns the hero similarity layout computed by
+// heroSimilarity. If hero is set only its similar heroes are returned.
+func (h *hotsContext) GetHeroSimilarity(ctx context.Context, r *http.Request) (interface{}, error) {
+	init := h.getInit()
+	build := r.FormValue("build")
+	if build == "" {
+		return nil, errors.New("build required")
+	}
+	patch := init.config.Map["build"][build]
+	if patch == "" {
+		return nil, errors.Errorf("unrecognized build: %s", build)
+	}
+	var data []byte
+	if err := h.x.GetContext(ctx, &data, `
+		SELECT data
+		FROM herosimilarity
+		WHERE build = $1
+		`, patch); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hero := r.FormValue("hero")
+	if hero == "" {
+		return json.RawMessage(data), nil
+	}
+	var res heroSimilarity
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	p := res.Heroes[hero]
+	if p == nil {
+		return nil, errors.Errorf("unknown hero: %s", hero)
+	}
+	return p.Similar, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChrisHines/GoSkills/skills"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Only accounts with a number of games in this range are checked.
+	// Smurfs have few games by definition.
+	suspicionMinGames = 10
+	suspicionMaxGames = 200
+	// suspicionEarlyGames is the number of first games of an account used
+	// for its early winrate.
+	suspicionEarlyGames = 20
+	// suspicionSkillBand is the width of the skill brackets hero damage is
+	// compared within.
+	suspicionSkillBand = 5
+	// suspicionMinScore is the minimum score stored.
+	suspicionMinScore = 0.1
+	// configSuspicionThreshold is the config key of the suspicion score at
+	// or above which accounts are excluded from the leaderboard. If unset no
+	// accounts are excluded.
+	configSuspicionThreshold = "suspicion-threshold"
+)
+
+// suspicion describes how anomalous an account's trajectory is. Each
+// signal is scaled to [0, 1] and Score is their mean.
+type suspicion struct {
+	Score float64
+	Games int
+	// EarlyWinrate is the winrate of the account's first games.
+	EarlyWinrate float64
+	// SkillRise is the highest skill mean of any mode minus the initial
+	// mean, per game played.
+	SkillRise float64
+	// DamagePercentile is the average percentile of the account's hero
+	// damage per minute among games on the same hero by players with a
+	// similar skill.
+	DamagePercentile float64
+}
+
+func (s *suspicion) score() {
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(1, v))
+	}
+	early := clamp((s.EarlyWinrate - 0.5) / (0.8 - 0.5))
+	// Rising 15 points in 50 games is very fast.
+	rise := clamp(s.SkillRise / 0.3)
+	damage := clamp((s.DamagePercentile - 0.5) / (0.9 - 0.5))
+	s.Score = (early + rise + damage) / 3
+}
+
+type suspicionBand struct {
+	build string
+	hero  string
+	band  int
+}
+
+/*
+updateSuspicion computes suspicion scores of accounts with few games and
+stores them in the suspicion table. Hero damage is only compared in the
+newest builds since it depends on the skill computed by elo.
+*/
+func (h *hotsContext) updateSuspicion(ctx context.Context) error {
+	start := time.Now()
+	init := h.getInit()
+	var builds []interface{}
+	for _, b := range init.recentBuilds() {
+		builds = append(builds, init.config.build(b.ID))
+	}
+	if len(builds) == 0 {
+		return nil
+	}
+
+	type stats struct {
+		mean, sd float64
+	}
+	bands := make(map[suspicionBand]stats)
+	{
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT build, hero, band, avg(dpm), COALESCE(stddev(dpm), 0)
+			FROM (
+				SELECT
+					build,
+					hero,
+					floor(skill / %d)::INT AS band,
+					COALESCE((data->>'hero_damage')::FLOAT, 0) * 60 / length::FLOAT AS dpm
+				FROM players
+				WHERE build IN %s AND skill > 0 AND length > 0 AND NOT leaver
+			)
+			GROUP BY build, hero, band
+			`, suspicionSkillBand, makeValues(len(builds), 1)), builds...)
+		if err != nil {
+			return errors.Wrap(err, "band stats")
+		}
+		for rows.Next() {
+			var b suspicionBand
+			var s stats
+			if err := rows.Scan(&b.build, &b.hero, &b.band, &s.mean, &s.sd); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan band")
+			}
+			bands[b] = s
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "band rows")
+		}
+	}
+	recent := make(map[string]bool)
+	for _, b := range builds {
+		recent[b.(string)] = true
+	}
+
+	const candidates = `
+		SELECT region, blizzid
+		FROM players
+		GROUP BY region, blizzid
+		HAVING count(*) BETWEEN $1 AND $2
+	`
+	accounts := make(map[regionPlayer]*suspicion)
+	{
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT
+				region,
+				blizzid,
+				winner,
+				build,
+				hero,
+				-- Unrated games have a skill of 0.
+				NULLIF(skill, 0),
+				COALESCE((data->>'hero_damage')::FLOAT, 0) * 60 / greatest(length, 1)::FLOAT
+			FROM players
+			WHERE (region, blizzid) IN (%s)
+			ORDER BY region, blizzid, time
+			`, candidates), suspicionMinGames, suspicionMaxGames)
+		if err != nil {
+			return errors.Wrap(err, "fetch games")
+		}
+		var early, earlyWon, damaged int
+		var rp regionPlayer
+		var s *suspicion
+		finish := func() {
+			if s == nil {
+				return
+			}
+			if early > 0 {
+				s.EarlyWinrate = float64(earlyWon) / float64(early)
+			}
+			if damaged > 0 {
+				s.DamagePercentile /= float64(damaged)
+			}
+		}
+		for rows.Next() {
+			var p regionPlayer
+			var winner bool
+			var build, hero string
+			var skill sql.NullFloat64
+			var dpm float64
+			if err := rows.Scan(&p.region, &p.blizzid, &winner, &build, &hero, &skill, &dpm); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan game")
+			}
+			if s == nil || p != rp {
+				finish()
+				rp = p
+				s = new(suspicion)
+				accounts[p] = s
+				early, earlyWon, damaged = 0, 0, 0
+			}
+			s.Games++
+			if early < suspicionEarlyGames {
+				early++
+				if winner {
+					earlyWon++
+				}
+			}
+			if skill.Valid && recent[build] {
+				st, ok := bands[suspicionBand{build, hero, int(math.Floor(skill.Float64 / suspicionSkillBand))}]
+				if ok && st.sd > 0 {
+					z := (dpm - st.mean) / st.sd
+					s.DamagePercentile += 0.5 * (1 + math.Erf(z/math.Sqrt2))
+					damaged++
+				}
+			}
+		}
+		finish()
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "game rows")
+		}
+	}
+	{
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT region, blizzid, max(skill)
+			FROM playerskills
+			WHERE (region, blizzid) IN (%s)
+			GROUP BY region, blizzid
+			`, candidates), suspicionMinGames, suspicionMaxGames)
+		if err != nil {
+			return errors.Wrap(err, "fetch skills")
+		}
+		for rows.Next() {
+			var p regionPlayer
+			var skill float64
+			if err := rows.Scan(&p.region, &p.blizzid, &skill); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan skill")
+			}
+			if s := accounts[p]; s != nil && s.Games > 0 {
+				s.SkillRise = (skill - skills.DefaultGameInfo.InitialMean) / float64(s.Games)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "skill rows")
+		}
+	}
+
+	var flagged []interface{}
+	for p, s := range accounts {
+		s.score()
+		if s.Score < suspicionMinScore {
+			continue
+		}
+		flagged = append(flagged, p.region, p.blizzid, s.Score, s.Games, s.EarlyWinrate, s.SkillRise, s.DamagePercentile)
+	}
+	n := len(flagged) / 7
+	// Replace the table in one transaction so readers never see it empty.
+	if err := h.txn(ctx, func(txn *sqlx.Tx) error {
+		if _, err := txn.Exec(`DELETE FROM suspicion WHERE true`); err != nil {
+			return errors.Wrap(err, "clear suspicion")
+		}
+		const batch = 1000
+		for params := flagged; len(params) > 0; {
+			next := params
+			if len(next) > batch*7 {
+				next = next[:batch*7]
+			}
+			params = params[len(next):]
+			var buf bytes.Buffer
+			buf.WriteString(`INSERT INTO suspicion (region, blizzid, score, games, early_winrate, skill_rise, damage_percentile) VALUES `)
+			for i := 0; i < len(next); i += 7 {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				buf.WriteString(makeValues(7, i+1))
+			}
+			if _, err := txn.Exec(buf.String(), next...); err != nil {
+				return errors.Wrap(err, "insert suspicion")
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Println("suspicion: checked", len(accounts), "accounts, stored", n, "took", time.Since(start))
+	return nil
+}
+
+// suspicionExcluded returns the accounts to exclude from the leaderboard
+// as configured by configSuspicionThreshold.
+func (h *hotsContext) suspicionExcluded(ctx context.Context) (map[regionPlayer]bool, error) {
+	var s string
+	if err := h.x.GetContext(ctx, &s, "SELECT s FROM config WHERE key = $1", configSuspicionThreshold); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "get suspicion threshold")
+	}
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse suspicion threshold")
+	}
+	rows, err := h.db.QueryContext(ctx, `SELECT region, blizzid FROM suspicion WHERE score >= $1`, threshold)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch suspicion")
+	}
+	defer rows.Close()
+	excluded := make(map[regionPlayer]bool)
+	for rows.Next() {
+		var p regionPlayer
+		if err := rows.Scan(&p.region, &p.blizzid); err != nil {
+			return nil, err
+		}
+		excluded[p] = true
+	}
+	return excluded, rows.Err()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSessionGap is the default number of minutes between the end
+	// of a game and the start of the next for them to be in the same
+	// session.
+	defaultSessionGap = 30
+	// streakMinGames is the minimum length of a reported streak.
+	streakMinGames = 3
+)
+
+type skillPoint struct {
+	Game   int64
+	Time   time.Time
+	Length int
+	Hero   string
+	Winner bool
+	// Skill and SkillSigma are the rating mean and standard deviation
+	// after the game. They are nil if the game is unrated.
+	Skill      *float64
+	SkillSigma *float64 `db:"skill_sigma"`
+	// Change is the difference from the previous rated game.
+	Change *float64 `db:"-"`
+}
+
+type skillSession struct {
+	Start time.Time
+	End   time.Time
+	Total
+	// Change is the rating after the session's last rated game minus the
+	// rating before the session. For a player's first session it starts at
+	// the rating after the first rated game.
+	Change float64
+}
+
+type skillStreak struct {
+	Start  time.Time
+	End    time.Time
+	Games  int
+	Winner bool
+}
+
+type skillTimeline struct {
+	Points   []skillPoint
+	Sessions []skillSession
+	Streaks  []skillStreak
+	// Current is the current streak, positive for wins and negative for
+	// losses.
+	Current     int
+	LongestWin  int
+	LongestLoss int
+}
+
+// GetPlayerSkillTimeline returns a player's game by game ratings per mode
+// with their sessions and win and loss streaks. Games are in the same
+// session if the next starts within session (default 30) minutes of the end
+// of the previous. build, from and to are optional.
+func (h *hotsContext) GetPlayerSkillTimeline(ctx context.Context, r *http.Request) (interface{}, error) {
+	blizzid := r.FormValue("blizzid")
+	region := r.FormValue("region")
+	if blizzid == "" {
+		return nil, errors.New("no blizzid parameter")
+	}
+	if region == "" {
+		return nil, errors.New("no region parameter")
+	}
+	gap := time.Minute * defaultSessionGap
+	if v := r.FormValue("session"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse session")
+		}
+		gap = time.Minute * time.Duration(m)
+	}
+	init := h.getInit()
+	wheres := []string{"blizzid = $1", "region = $2"}
+	params := []interface{}{blizzid, region}
+	args := map[string]string{
+		"build": r.FormValue("build"),
+		"from":  r.FormValue("from"),
+		"to":    r.FormValue("to"),
+		"mode":  r.FormValue("mode"),
+	}
+	if err := setOptionalBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "mode"); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		skillPoint
+		Mode Mode
+	}
+	if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+		SELECT
+			game, time, length, hero, winner, mode,
+			-- Unrated games have a skill of 0.
+			NULLIF(skill, 0) AS skill,
+			CASE WHEN skill = 0 THEN NULL ELSE skill_sigma END AS skill_sigma
+		FROM players
+		WHERE %s
+		ORDER BY time
+		`, strings.Join(wheres, " AND ")), params...); err != nil {
+		return nil, err
+	}
+	res := make(map[Mode]*skillTimeline)
+	for _, row := range rows {
+		p := row.skillPoint
+		p.Hero = init.lookups["hero"](p.Hero)
+		t := res[row.Mode]
+		if t == nil {
+			t = new(skillTimeline)
+			res[row.Mode] = t
+		}
+		t.Points = append(t.Points, p)
+	}
+	for _, t := range res {
+		t.compute(gap)
+	}
+	return res, nil
+}
+
+func (t *skillTimeline) compute(gap time.Duration) {
+	var prev *float64
+	var session *skillSession
+	// before is the rating before the current session.
+	var before *float64
+	var streak *skillStreak
+	end := func(p *skillPoint) time.Time {
+		return p.Time.Add(time.Duration(p.Length) * time.Second)
+	}
+	for i := range t.Points {
+		p := &t.Points[i]
+		if p.Skill != nil && prev != nil {
+			c := *p.Skill - *prev
+			p.Change = &c
+		}
+
+		if session == nil || p.Time.Sub(session.End) > gap {
+			t.Sessions = append(t.Sessions, skillSession{Start: p.Time})
+			session = &t.Sessions[len(t.Sessions)-1]
+			before = prev
+		}
+		if before == nil {
+			before = p.Skill
+		}
+		session.End = end(p)
+		if p.Winner {
+			session.Wins++
+		} else {
+			session.Losses++
+		}
+		if p.Skill != nil && before != nil {
+			session.Change = *p.Skill - *before
+		}
+
+		if streak == nil || streak.Winner != p.Winner {
+			if streak != nil && streak.Games >= streakMinGames {
+				t.Streaks = append(t.Streaks, *streak)
+			}
+			streak = &skillStreak{Start: p.Time, Winner: p.Winner}
+		}
+		streak.Games++
+		streak.End = end(p)
+		if p.Winner && streak.Games > t.LongestWin {
+			t.LongestWin = streak.Games
+		} else if !p.Winner && streak.Games > t.LongestLoss {
+			t.LongestLoss = streak.Games
+		}
+
+		if p.Skill != nil {
+			prev = p.Skill
+		}
+	}
+	if streak != nil {
+		if streak.Games >= streakMinGames {
+			t.Streaks = append(t.Streaks, *streak)
+		}
+		t.Current = streak.Games
+		if !streak.Winner {
+			t.Current = -streak.Games
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSkillTimeline(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	// point returns a 20 minute game starting at minutes. A skill of 0 is
+	// unrated.
+	point := func(minutes int, winner bool, skill float64) skillPoint {
+		p := skillPoint{Time: at(minutes), Length: 20 * 60, Winner: winner}
+		if skill != 0 {
+			p.Skill = &skill
+		}
+		return p
+	}
+	const day = 24 * 60
+	// daily are the results of games a day apart, each its own session.
+	daily := []bool{true, true, true, false, false, false, false, true}
+	var dailyPoints []skillPoint
+	var dailySessions []skillSession
+	for i, winner := range daily {
+		dailyPoints = append(dailyPoints, point(i*day, winner, 0))
+		s := skillSession{Start: at(i * day), End: at(i*day + 20)}
+		if winner {
+			s.Wins = 1
+		} else {
+			s.Losses = 1
+		}
+		dailySessions = append(dailySessions, s)
+	}
+	tests := []struct {
+		name   string
+		points []skillPoint
+		// changes are the Change of each point, "" if nil.
+		changes []string
+		expect  skillTimeline
+	}{
+		{
+			name: "sessions",
+			points: []skillPoint{
+				point(0, true, 25),
+				// Starts 5 minutes after the previous ended.
+				point(25, false, 0),
+				point(50, true, 27),
+				// Starts 50 minutes after the previous ended.
+				point(120, false, 24),
+			},
+			changes: []string{"", "", "2", "-3"},
+			expect: skillTimeline{
+				Sessions: []skillSession{
+					{Start: at(0), End: at(70), Total: Total{Wins: 2, Losses: 1}, Change: 2},
+					{Start: at(120), End: at(140), Total: Total{Losses: 1}, Change: -3},
+				},
+				Current:     -1,
+				LongestWin:  1,
+				LongestLoss: 1,
+			},
+		},
+		{
+			name: "session gap",
+			points: []skillPoint{
+				point(0, true, 25),
+				// Exactly 30 minutes after the previous ended.
+				point(50, true, 26),
+				point(101, true, 27),
+			},
+			changes: []string{"", "1", "1"},
+			expect: skillTimeline{
+				Sessions: []skillSession{
+					{Start: at(0), End: at(70), Total: Total{Wins: 2}, Change: 1},
+					{Start: at(101), End: at(121), Total: Total{Wins: 1}, Change: 1},
+				},
+				Streaks: []skillStreak{
+					{Start: at(0), End: at(121), Games: 3, Winner: true},
+				},
+				Current:    3,
+				LongestWin: 3,
+			},
+		},
+		{
+			name: "first game unrated",
+			points: []skillPoint{
+				point(0, false, 0),
+				point(25, true, 25),
+				point(50, true, 26),
+			},
+			changes: []string{"", "", "1"},
+			expect: skillTimeline{
+				Sessions: []skillSession{
+					{Start: at(0), End: at(70), Total: Total{Wins: 2, Losses: 1}, Change: 1},
+				},
+				Current:     2,
+				LongestWin:  2,
+				LongestLoss: 1,
+			},
+		},
+		{
+			name:    "streaks",
+			points:  dailyPoints,
+			changes: []string{"", "", "", "", "", "", "", ""},
+			expect: skillTimeline{
+				Sessions: dailySessions,
+				Streaks: []skillStreak{
+					{Start: at(0 * day), End: at(2*day + 20), Games: 3, Winner: true},
+					{Start: at(3 * day), End: at(6*day + 20), Games: 4},
+				},
+				Current:     1,
+				LongestWin:  3,
+				LongestLoss: 4,
+			},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := skillTimeline{Points: tc.points}
+			tl.compute(time.Minute * defaultSessionGap)
+			var changes []string
+			for _, p := range tl.Points {
+				s := ""
+				if p.Change != nil {
+					s = strconv.FormatFloat(*p.Change, 'f', -1, 64)
+				}
+				changes = append(changes, s)
+			}
+			if !reflect.DeepEqual(changes, tc.changes) {
+				t.Fatalf("expected changes %q, got %q", tc.changes, changes)
+			}
+			tl.Points = nil
+			if !reflect.DeepEqual(tl, tc.expect) {
+				t.Fatalf("expected %+v, got %+v", tc.expect, tl)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// regionTimezones are the default timezones of regions, used when no tz
+// argument is given.
+var regionTimezones = map[int]string{
+	1: "America/Chicago",
+	2: "Europe/Berlin",
+	3: "Asia/Seoul",
+	5: "Asia/Shanghai",
+}
+
+type timeBucket struct {
+	Total
+	Winrate float64
+	// Skill and SkillStddev are the mean and standard deviation of the
+	// players' skill in rated games. Unrated games have a skill of 0.
+	Skill       float64
+	SkillStddev float64
+
+	skills, sum, sumSq float64
+}
+
+func (t *timeBucket) add(winner bool, count int, skills, sum, sumSq float64) {
+	if winner {
+		t.Wins += count
+	} else {
+		t.Losses += count
+	}
+	t.skills += skills
+	t.sum += sum
+	t.sumSq += sumSq
+}
+
+func (t *timeBucket) finish() {
+	if n := t.Wins + t.Losses; n > 0 {
+		t.Winrate = float64(t.Wins) / float64(n)
+	}
+	if t.skills > 0 {
+		t.Skill = t.sum / t.skills
+		t.SkillStddev = math.Sqrt(math.Max(0, t.sumSq/t.skills-t.Skill*t.Skill))
+	}
+}
+
+/*
+GetTimeOfDay returns winrates and skill by local hour of day and weekday
+(0 is Sunday). If blizzid and region are set only that player's games are
+used. Games are converted to the timezone of the tz argument (an IANA name
+like America/New_York) or else each game's region default. Games are
+grouped by UTC hour before conversion, so timezones with non-hour offsets
+are rounded down.
+*/
+func (h *hotsContext) GetTimeOfDay(ctx context.Context, r *http.Request) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
+	init := h.getInit()
+	var tz *time.Location
+	if v := r.FormValue("tz"); v != "" {
+		var err error
+		tz, err = time.LoadLocation(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tz")
+		}
+	}
+	regionTZ := make(map[int]*time.Location)
+	for region, name := range regionTimezones {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load region tz: %s", name)
+		}
+		regionTZ[region] = loc
+	}
+
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
+	}
+	if blizzid := r.FormValue("blizzid"); blizzid != "" {
+		if args["region"] == "" {
+			return nil, errors.New("region required with blizzid")
+		}
+		wheres = append(wheres, fmt.Sprintf("blizzid = $%d", len(params)+1))
+		params = append(params, blizzid)
+	}
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			date_trunc('hour', time) AS hour,
+			region,
+			winner,
+			count(*),
+			count(NULLIF(skill, 0))::FLOAT,
+			COALESCE(sum(NULLIF(skill, 0)), 0),
+			COALESCE(sum(NULLIF(skill, 0) * NULLIF(skill, 0)), 0)
+		FROM players
+		WHERE %s
+		GROUP BY hour, region, winner
+		`, strings.Join(wheres, " AND ")), params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch hours")
+	}
+	defer rows.Close()
+	hours := make(map[int]*timeBucket)
+	weekdays := make(map[int]*timeBucket)
+	get := func(m map[int]*timeBucket, k int) *timeBucket {
+		t := m[k]
+		if t == nil {
+			t = new(timeBucket)
+			m[k] = t
+		}
+		return t
+	}
+	for rows.Next() {
+		var hour time.Time
+		var region, count int
+		var winner bool
+		var skills, sum, sumSq sql.NullFloat64
+		if err := rows.Scan(&hour, &region, &winner, &count, &skills, &sum, &sumSq); err != nil {
+			return nil, errors.Wrap(err, "scan")
+		}
+		loc := tz
+		if loc == nil {
+			loc = regionTZ[region]
+		}
+		if loc == nil {
+			loc = time.UTC
+		}
+		local := hour.In(loc)
+		get(hours, local.Hour()).add(winner, count, skills.Float64, sum.Float64, sumSq.Float64)
+		get(weekdays, int(local.Weekday())).add(winner, count, skills.Float64, sum.Float64, sumSq.Float64)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows")
+	}
+
+	var res struct {
+		// Timezone is the tz argument or empty if region defaults were used.
+		Timezone string
+		Hours    map[string]timeBucket
+		Weekdays map[string]timeBucket
+	}
+	if tz != nil {
+		res.Timezone = tz.String()
+	}
+	res.Hours = make(map[string]timeBucket, len(hours))
+	for k, t := range hours {
+		t.finish()
+		res.Hours[strconv.Itoa(k)] = *t
+	}
+	res.Weekdays = make(map[string]timeBucket, len(weekdays))
+	for k, t := range weekdays {
+		t.finish()
+		res.Weekdays[strconv.Itoa(k)] = *t
+	}
+	return res, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// getInterval returns the interval argument of r, which is either "day" or
+// "week", or def if unset.
+func getInterval(r *http.Request, def string) (string, error) {
+	interval := r.FormValue("interval")
+	if interval == "" {
+		interval = def
+	}
+	if interval != "day" && interval != "week" {
+		return "", errors.Errorf("unknown interval: %s", interval)
+	}
+	return interval, nil
+}
+
+type heroTimePoint struct {
+	Date string
+	// Total counts the games with a hero level of at least herolevel, and
+	// is used for Winrate.
+	Total
+	// Picks counts all games on the hero, whatever their hero level, so
+	// that PickRate has the same population as Games.
+	Picks int
+	Bans  int
+	// Games is the number of games in the interval.
+	Games    int
+	PickRate float64
+	BanRate  float64
+	Winrate  float64
+}
+
+// GetHeroTimeseries returns pick, ban and win rates of heroes per day or
+// week. Unlike the other aggregate endpoints it is meant to span builds, so
+// the builds in the range are returned with their start dates.
+func (h *hotsContext) GetHeroTimeseries(ctx context.Context, r *http.Request) (interface{}, error) {
+	args := map[string]string{
+		"build":           r.FormValue("build"),
+		"hero":            r.FormValue("hero"),
+		"herolevel":       r.FormValue("herolevel"),
+		"map":             r.FormValue("map"),
+		"mode":            r.FormValue("mode"),
+		"region":          r.FormValue("region"),
+		"from":            r.FormValue("from"),
+		"to":              r.FormValue("to"),
+		"exclude_leavers": r.FormValue("exclude_leavers"),
+	}
+	if args["hero"] == "" {
+		return nil, errors.New("hero required")
+	}
+	interval, err := getInterval(r, "day")
+	if err != nil {
+		return nil, err
+	}
+	init := h.getInit()
+
+	var res struct {
+		Interval string
+		Builds   []Build
+		Heroes   map[string][]heroTimePoint
+	}
+	res.Interval = interval
+	builds, err := init.builds(args)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range builds {
+		for _, b := range init.Builds {
+			if b.ID == id {
+				res.Builds = append(res.Builds, b)
+			}
+		}
+	}
+
+	// Games and bans are counted from the games table, which doesn't have
+	// hero_level, so they get their own filters.
+	var gameWheres []string
+	var gameParams []interface{}
+	if err := setBuildParams(init, &gameWheres, &gameParams, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &gameWheres, &gameParams, args, "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&gameWheres, args); err != nil {
+		return nil, err
+	}
+	var wheres []string
+	var params []interface{}
+	if err := setBuildParams(init, &wheres, &params, args); err != nil {
+		return nil, err
+	}
+	if err := setArgParams(init, &wheres, &params, args, "hero", "map", "mode", "region"); err != nil {
+		return nil, err
+	}
+	if err := setLeaverParams(&wheres, args); err != nil {
+		return nil, err
+	}
+	// The herolevel filter only applies to winrates; picks are counted at
+	// all levels to match the games denominator.
+	hl := args["herolevel"]
+	if hl == "" {
+		hl = defaultHerolevel
+	}
+	params = append(params, hl)
+	leveled := fmt.Sprintf("hero_level >= $%d", len(params))
+
+	heroIDs := make(map[string]bool)
+	for _, hero := range strings.Split(args["hero"], ",") {
+		heroIDs[init.config.hero(hero)] = true
+	}
+
+	type key struct {
+		date string
+		hero string
+	}
+	var games map[string]int
+	winrates := make(map[key]Total)
+	picks := make(map[key]int)
+	bans := make(map[key]int)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var rows []struct {
+			Date  time.Time
+			Count int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT date_trunc('%s', time) AS date, count(*) AS count
+			FROM games
+			WHERE %s
+			GROUP BY date
+			`, interval, strings.Join(gameWheres, " AND ")), gameParams...); err != nil {
+			return errors.Wrap(err, "games")
+		}
+		games = make(map[string]int, len(rows))
+		for _, r := range rows {
+			games[r.Date.Format(dateFormat)] = r.Count
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var rows []struct {
+			Date  time.Time
+			Ban   string
+			Count int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT date, ban, count(*) AS count
+			FROM (
+				SELECT date_trunc('%s', time) AS date, unnest(bans) AS ban
+				FROM games
+				WHERE %s
+			)
+			GROUP BY date, ban
+			`, interval, strings.Join(gameWheres, " AND ")), gameParams...); err != nil {
+			return errors.Wrap(err, "bans")
+		}
+		for _, r := range rows {
+			if heroIDs[r.Ban] {
+				bans[key{r.Date.Format(dateFormat), r.Ban}] += r.Count
+			}
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var rows []struct {
+			Date    time.Time
+			Hero    string
+			Winner  bool
+			Count   int
+			Leveled int
+		}
+		if err := h.x.SelectContext(ctx, &rows, fmt.Sprintf(`
+			SELECT
+				date_trunc('%s', time) AS date, hero, winner, count(*) AS count,
+				sum(CASE WHEN %s THEN 1 ELSE 0 END) AS leveled
+			FROM players
+			WHERE %s
+			GROUP BY date, hero, winner
+			`, interval, leveled, strings.Join(wheres, " AND ")), params...); err != nil {
+			return errors.Wrap(err, "picks")
+		}
+		for _, r := range rows {
+			k := key{r.Date.Format(dateFormat), r.Hero}
+			picks[k] += r.Count
+			t := winrates[k]
+			if r.Winner {
+				t.Wins += r.Leveled
+			} else {
+				t.Losses += r.Leveled
+			}
+			winrates[k] = t
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var dates []string
+	for d := range games {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+	res.Heroes = make(map[string][]heroTimePoint)
+	for id := range heroIDs {
+		name := init.lookups["hero"](id)
+		points := make([]heroTimePoint, 0, len(dates))
+		for _, d := range dates {
+			k := key{d, id}
+			p := heroTimePoint{
+				Date:  d,
+				Total: winrates[k],
+				Picks: picks[k],
+				Bans:  bans[k],
+				Games: games[d],
+			}
+			if p.Games > 0 {
+				p.PickRate = float64(p.Picks) / float64(p.Games)
+				p.BanRate = float64(p.Bans) / float64(p.Games)
+			}
+			if n := p.Wins + p.Losses; n > 0 {
+				p.Winrate = float64(p.Wins) / float64(n)
+			}
+			points = append(points, p)
+		}
+		res.Heroes[name] = points
+	}
+	return res, nil
+}
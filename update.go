@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,6 +39,9 @@ const (
 	perFile    = 10000
 	perRequest = 100
 	configBase = "%09d.csv"
+
+	// nextUpdateKey is the config key of the ID of the next block to ingest.
+	nextUpdateKey = "next-update"
 )
 
 func (h *hotsContext) updateDB() error {
@@ -73,6 +77,18 @@ func (h *hotsContext) updateDB() error {
 					if err := h.elo(); err != nil {
 						return errors.Wrap(err, "elo")
 					}
+					if err := h.winFactors(); err != nil {
+						return errors.Wrap(err, "win factors")
+					}
+					if err := h.heroCombos(); err != nil {
+						return errors.Wrap(err, "hero combos")
+					}
+					if err := h.heroSimilarity(); err != nil {
+						return errors.Wrap(err, "hero similarity")
+					}
+					if err := h.heroStatDists(); err != nil {
+						return errors.Wrap(err, "hero stat distributions")
+					}
 				}
 				if err := h.cronLoop(); err != nil {
 					return errors.Wrap(err, "cronLoop")
@@ -90,7 +106,6 @@ func (h *hotsContext) updateDB() error {
 
 func (h *hotsContext) updateDBNext(bucket *storage.BucketHandle) error {
 	ctx := context.Background()
-	const nextUpdateKey = "next-update"
 	var start int
 	if err := h.x.Get(&start, `SELECT i FROM config WHERE key = $1`, nextUpdateKey); err != nil {
 		return err
@@ -195,6 +210,18 @@ func (h *hotsContext) updateDBNext(bucket *storage.BucketHandle) error {
 	if err := copyin(players, "players", []string{"game", "mode", "time", "map", "length", "build", "region", "hero", "hero_level", "team", "winner", "blizzid", "skill", "battletag", "talents", "data"}); err != nil {
 		return errors.Wrap(err, "copy players")
 	}
+	if err := h.markLeavers(int64(start), int64(start+perFile)); err != nil {
+		return errors.Wrap(err, "mark leavers")
+	}
+	if err := h.countHeroGames(int64(start), int64(start+perFile)); err != nil {
+		return errors.Wrap(err, "count hero games")
+	}
+	if err := h.updateBattletags(int64(start), int64(start+perFile)); err != nil {
+		return errors.Wrap(err, "battletags")
+	}
+	if err := h.updateCoplay(int64(start), int64(start+perFile)); err != nil {
+		return errors.Wrap(err, "coplay")
+	}
 	if _, err := h.db.Exec(`UPDATE config SET i = $1 WHERE key = $2`, start+perFile, nextUpdateKey); err != nil {
 		return errors.Wrap(err, "update config")
 	}
@@ -353,6 +380,7 @@ func updateNextGroup(
 					}
 				}
 				version := strings.Join(strings.Split(r.GameVersion, ".")[:3], ".")
+				version = config.segment(version, time.Time(r.GameDate))
 				config.addBuild(version, time.Time(r.GameDate))
 				common := []string{
 					fmt.Sprint(r.ID),
@@ -442,6 +470,131 @@ func updateNextGroup(
 	return config.NextID, nil
 }
 
+// resegment splits the already imported games of each version in the
+// Hotfixes config into their hotfix segments. The updated config is written
+// back to the bucket, so updateNew must not be running.
+func (h *hotsContext) resegment(bucketName string) error {
+	ctx := context.Background()
+	cl, err := storage.NewClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "new client")
+	}
+	config, err := getConfig(ctx, bucketName)
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	for version, hotfixes := range config.Hotfixes {
+		sort.Slice(hotfixes, func(i, j int) bool {
+			return hotfixes[i].Before(hotfixes[j])
+		})
+		if config.Map["build"][version] == "" {
+			return errors.Errorf("unknown version: %s", version)
+		}
+		// All existing segments of version, including those of hotfixes that
+		// have since been removed.
+		segments := buildIDs(config, version)
+		for i := 0; i <= len(hotfixes); i++ {
+			name := version
+			wheres := []string{fmt.Sprintf("build IN %s", makeValues(len(segments), 2))}
+			params := append([]interface{}{nil}, segments...)
+			if i > 0 {
+				name = hotfixBuild(version, i)
+				wheres = append(wheres, fmt.Sprintf("time >= $%d", len(params)+1))
+				params = append(params, hotfixes[i-1])
+			}
+			if i < len(hotfixes) {
+				wheres = append(wheres, fmt.Sprintf("time < $%d", len(params)+1))
+				params = append(params, hotfixes[i])
+			}
+			id := config.build(name)
+			params[0] = id
+			wheres = append(wheres, "build != $1")
+			for _, table := range []string{"games", "players"} {
+				// Update in batches to keep transactions small.
+				for {
+					var n int64
+					if err := retry(func() error {
+						res, err := h.db.Exec(fmt.Sprintf(
+							"UPDATE %s SET build = $1 WHERE %s LIMIT 10000",
+							table, strings.Join(wheres, " AND "),
+						), params...)
+						if err != nil {
+							return err
+						}
+						n, err = res.RowsAffected()
+						return err
+					}); err != nil {
+						return errors.Wrapf(err, "update %s", table)
+					}
+					fmt.Println("resegment", table, name, n)
+					if n == 0 {
+						break
+					}
+				}
+			}
+		}
+
+		// Recompute the date ranges of the segments.
+		ids := buildIDs(config, version)
+		names := make(map[string]string)
+		for name, id := range config.Map["build"] {
+			names[id] = name
+		}
+		var ranges []struct {
+			Build  string
+			Start  time.Time
+			Finish time.Time
+		}
+		if err := h.x.Select(&ranges, fmt.Sprintf(`
+			SELECT build, min(time) AS start, max(time) AS finish
+			FROM games
+			WHERE build IN %s
+			GROUP BY build
+			`, makeValues(len(ids), 1)), ids...); err != nil {
+			return errors.Wrap(err, "build ranges")
+		}
+		for name := range config.Builds {
+			if buildVersion(name) == version {
+				delete(config.Builds, name)
+			}
+		}
+		for _, r := range ranges {
+			config.addBuild(names[r.Build], r.Start)
+			config.addBuild(names[r.Build], r.Finish)
+		}
+
+		// Stats of the old segments are stale. They are recomputed by the
+		// next elo run.
+		for _, table := range []string{"playerskills", "skillstats", "regionskillstats"} {
+			if _, err := h.db.Exec(fmt.Sprintf(
+				"DELETE FROM %s WHERE build IN %s",
+				table, makeValues(len(ids), 1),
+			), ids...); err != nil {
+				return errors.Wrapf(err, "clear %s", table)
+			}
+		}
+	}
+	cw := cl.Bucket(bucketName).Object(configJSON).NewWriter(ctx)
+	if err := json.NewEncoder(cw).Encode(config); err != nil {
+		return errors.Wrap(err, "json encode")
+	}
+	if err := cw.Close(); err != nil {
+		return errors.Wrap(err, "write config")
+	}
+	return h.syncConfig(bucketName)
+}
+
+// buildIDs returns the IDs of all builds of version.
+func buildIDs(config *groupConfig, version string) []interface{} {
+	var ids []interface{}
+	for name, id := range config.Map["build"] {
+		if buildVersion(name) == version {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // groupWorkers creates num worker go routines in an error group.
 func groupWorkers(ctx context.Context, num int, f func(context.Context) error) error {
 	group, ctx := errgroup.WithContext(ctx)
@@ -719,6 +872,9 @@ type groupConfig struct {
 	NextID   int
 	Map      map[string]map[string]string
 	Builds   map[string]dateRange
+	// Hotfixes are the times of balance changes that didn't change the
+	// version, by version. They are maintained by hand.
+	Hotfixes map[string][]time.Time
 }
 
 type dateRange struct {
@@ -742,6 +898,36 @@ func (g *groupConfig) addBuild(name string, date time.Time) {
 	g.Builds[name] = b
 }
 
+const hotfixSep = "-hf"
+
+// segment returns the build name of a game of version played at date.
+// Each hotfix of version starts a new build named by hotfixBuild.
+func (g *groupConfig) segment(version string, date time.Time) string {
+	n := 0
+	for _, t := range g.Hotfixes[version] {
+		if !date.Before(t) {
+			n++
+		}
+	}
+	if n == 0 {
+		return version
+	}
+	return hotfixBuild(version, n)
+}
+
+// hotfixBuild returns the build name of the nth hotfix segment of version,
+// like "2.30.0-hf01". Builds are sorted by name, so the number is zero padded
+// for "-hf10" to sort after "-hf02", and the segments sort after the version.
+// Running resegment moves games of builds named by an older scheme.
+func hotfixBuild(version string, n int) string {
+	return fmt.Sprintf("%s%s%02d", version, hotfixSep, n)
+}
+
+// buildVersion returns the version of a build, without any hotfix segment.
+func buildVersion(build string) string {
+	return strings.SplitN(build, hotfixSep, 2)[0]
+}
+
 func (g *groupConfig) build(name string) string {
 	return g.get("build", name)
 }
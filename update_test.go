@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSegment(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2018, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	var hotfixes []time.Time
+	for d := 2; d <= 11; d++ {
+		hotfixes = append(hotfixes, day(d))
+	}
+	g := groupConfig{Hotfixes: map[string][]time.Time{"2.30.0": hotfixes}}
+	tests := []struct {
+		date   time.Time
+		expect string
+	}{
+		{day(1), "2.30.0"},
+		{day(2), "2.30.0-hf01"},
+		{day(3).Add(-time.Second), "2.30.0-hf01"},
+		{day(3), "2.30.0-hf02"},
+		{day(11), "2.30.0-hf10"},
+	}
+	var names []string
+	for _, tc := range tests {
+		t.Run(tc.date.String(), func(t *testing.T) {
+			res := g.segment("2.30.0", tc.date)
+			if res != tc.expect {
+				t.Fatalf("expected %v, got %v", tc.expect, res)
+			}
+			if v := buildVersion(res); v != "2.30.0" {
+				t.Fatalf("expected version 2.30.0, got %v", v)
+			}
+		})
+		names = append(names, tc.expect)
+	}
+	// Builds are sorted by name, which must match the order of the segments.
+	if !sort.StringsAreSorted(names) {
+		t.Fatalf("expected %q to be sorted", names)
+	}
+}
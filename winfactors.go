@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// teamStats are the score stats totaled per team (see teamStat) to find
+// what wins games.
+var teamStats = []struct {
+	Name string
+	Key  string
+}{
+	{"HeroDamage", "hero_damage"},
+	{"SiegeDamage", "siege_damage"},
+	{"Healing", "healing"},
+	{"ExperienceContribution", "experience_contribution"},
+	{"MercCampCaptures", "merc_camp_captures"},
+	{"Deaths", "deaths"},
+}
+
+// sharedTeamStats are score stats that the score screen credits to every
+// player who took part, such as a camp capture. Summing them over a team
+// would count shared captures several times, so a team's value is the
+// largest of its players'.
+var sharedTeamStats = map[string]bool{
+	"merc_camp_captures":   true,
+	"watch_tower_captures": true,
+}
+
+// teamStat returns the SQL aggregate of a score stat over a team's players.
+func teamStat(key string) string {
+	agg := "sum"
+	if sharedTeamStats[key] {
+		agg = "max"
+	}
+	return fmt.Sprintf("%s((data->>'%s')::INT)", agg, key)
+}
+
+// winFactorsMinGames is the minimum number of games needed to compute win
+// factors for a map and mode.
+const winFactorsMinGames = 100
+
+type winFactor struct {
+	Stat string
+	// WinnerMean and LoserMean are the average team totals.
+	WinnerMean float64
+	LoserMean  float64
+	// EffectSize is the mean winner minus loser difference divided by its
+	// standard deviation.
+	EffectSize float64
+	// AheadWinrate is how often the team with more of the stat won.
+	AheadWinrate float64
+	// Coefficient is the logistic regression coefficient of the
+	// standardized team difference, controlling for the other stats.
+	Coefficient float64
+}
+
+type winFactors struct {
+	Games   int
+	Factors []winFactor
+}
+
+type teamGame struct {
+	winner [2]bool
+	stats  [2][]float64
+}
+
+/*
+winFactors computes which team stat differences best predict the winner
+of a game for each map and mode of the newest builds. Map or mode 0 is
+all maps or modes. Results are stored in the winfactors table.
+*/
+func (h *hotsContext) winFactors() error {
+	ctx := context.Background()
+	if err := h.updateInit(ctx); err != nil {
+		return err
+	}
+	init := h.getInit()
+	for _, b := range init.recentBuilds() {
+		start := time.Now()
+		patch := init.config.build(b.ID)
+		var sums []string
+		for _, s := range teamStats {
+			sums = append(sums, fmt.Sprintf("COALESCE(%s, 0)::FLOAT", teamStat(s.Key)))
+		}
+		rows, err := h.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT game, map, mode, team, bool_or(winner), %s
+			FROM players
+			WHERE build = $1
+			GROUP BY game, map, mode, team
+			`, strings.Join(sums, ", ")), patch)
+		if err != nil {
+			return errors.Wrap(err, "fetch teams")
+		}
+		type mapMode struct {
+			gamemap int
+			mode    Mode
+		}
+		games := make(map[int64]*teamGame)
+		groups := make(map[mapMode][]int64)
+		for rows.Next() {
+			var id int64
+			var mm mapMode
+			var team int
+			var winner bool
+			stats := make([]float64, len(teamStats))
+			dest := []interface{}{&id, &mm.gamemap, &mm.mode, &team, &winner}
+			for i := range stats {
+				dest = append(dest, &stats[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "scan")
+			}
+			if team != 0 && team != 1 {
+				continue
+			}
+			g := games[id]
+			if g == nil {
+				g = new(teamGame)
+				games[id] = g
+				for _, k := range []mapMode{mm, {0, mm.mode}, {mm.gamemap, 0}, {0, 0}} {
+					groups[k] = append(groups[k], id)
+				}
+			}
+			g.winner[team] = winner
+			g.stats[team] = stats
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "rows")
+		}
+		fmt.Println("win factors", b.ID, "fetched", len(games), "games", time.Since(start))
+		for mm, ids := range groups {
+			var tgs []*teamGame
+			for _, id := range ids {
+				g := games[id]
+				// Skip games missing a team or without a single winner.
+				if g.stats[0] == nil || g.stats[1] == nil || g.winner[0] == g.winner[1] {
+					continue
+				}
+				tgs = append(tgs, g)
+			}
+			if len(tgs) < winFactorsMinGames {
+				continue
+			}
+			data, err := json.Marshal(computeWinFactors(tgs))
+			if err != nil {
+				return err
+			}
+			if err := retry(func() error {
+				_, err := h.db.Exec(`UPSERT INTO winfactors (build, map, mode, data) VALUES ($1, $2, $3, $4)`,
+					patch, mm.gamemap, mm.mode, data,
+				)
+				return err
+			}); err != nil {
+				return errors.Wrap(err, "upsert winfactors")
+			}
+		}
+		fmt.Println("win factors", b.ID, "took", time.Since(start))
+	}
+	return nil
+}
+
+func computeWinFactors(games []*teamGame) winFactors {
+	res := winFactors{Games: len(games)}
+	n := float64(len(games))
+	// x are the team 0 minus team 1 differences, y is whether team 0 won.
+	x := make([][]float64, len(games))
+	y := make([]float64, len(games))
+	for i, g := range games {
+		x[i] = make([]float64, len(teamStats))
+		for j := range teamStats {
+			x[i][j] = g.stats[0][j] - g.stats[1][j]
+		}
+		if g.winner[0] {
+			y[i] = 1
+		}
+	}
+	for j, s := range teamStats {
+		f := winFactor{Stat: s.Name}
+		var sum, sumSq float64
+		ahead, aheadWon := 0, 0
+		for _, g := range games {
+			w, l := 0, 1
+			if g.winner[1] {
+				w, l = 1, 0
+			}
+			d := g.stats[w][j] - g.stats[l][j]
+			f.WinnerMean += g.stats[w][j]
+			f.LoserMean += g.stats[l][j]
+			sum += d
+			sumSq += d * d
+			if d != 0 {
+				ahead++
+				if d > 0 {
+					aheadWon++
+				}
+			}
+		}
+		f.WinnerMean /= n
+		f.LoserMean /= n
+		mean := sum / n
+		if sd := math.Sqrt(sumSq/n - mean*mean); sd > 0 {
+			f.EffectSize = mean / sd
+		}
+		if ahead > 0 {
+			f.AheadWinrate = float64(aheadWon) / float64(ahead)
+		}
+		res.Factors = append(res.Factors, f)
+
+		// Standardize so coefficients are comparable between stats.
+		var xSumSq float64
+		for i := range x {
+			xSumSq += x[i][j] * x[i][j]
+		}
+		if sd := math.Sqrt(xSumSq / n); sd > 0 {
+			for i := range x {
+				x[i][j] /= sd
+			}
+		}
+	}
+	for j, c := range logisticRegression(x, y) {
+		res.Factors[j].Coefficient = c
+	}
+	sort.Slice(res.Factors, func(i, j int) bool {
+		return math.Abs(res.Factors[i].EffectSize) > math.Abs(res.Factors[j].EffectSize)
+	})
+	return res
+}
+
+// logisticRegression fits P(y) = 1 / (1 + exp(-x·b)) with Newton's method
+// and returns b. A small ridge penalty keeps collinear stats stable. There
+// is no intercept since x are differences between symmetric teams.
+func logisticRegression(x [][]float64, y []float64) []float64 {
+	const (
+		iterations = 25
+		ridge      = 1e-3
+	)
+	k := len(x[0])
+	b := make([]float64, k)
+	for iter := 0; iter < iterations; iter++ {
+		grad := make([]float64, k)
+		hess := make([][]float64, k)
+		for j := range hess {
+			hess[j] = make([]float64, k)
+			grad[j] = -ridge * b[j]
+			hess[j][j] = ridge
+		}
+		for i, xi := range x {
+			var z float64
+			for j := range xi {
+				z += xi[j] * b[j]
+			}
+			p := 1 / (1 + math.Exp(-z))
+			w := p * (1 - p)
+			for j := range xi {
+				grad[j] += (y[i] - p) * xi[j]
+				for l := range xi {
+					hess[j][l] += w * xi[j] * xi[l]
+				}
+			}
+		}
+		step, ok := solve(hess, grad)
+		if !ok {
+			break
+		}
+		var size float64
+		for j := range b {
+			b[j] += step[j]
+			size += math.Abs(step[j])
+		}
+		if size < 1e-8 {
+			break
+		}
+	}
+	return b
+}
+
+// solve returns x such that a·x = v using Gaussian elimination with partial
+// pivoting. a and v are modified. It returns false if a is singular.
+func solve(a [][]float64, v []float64) ([]float64, bool) {
+	n := len(v)
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+		if math.Abs(a[p][c]) < 1e-12 {
+			return nil, false
+		}
+		a[c], a[p] = a[p], a[c]
+		v[c], v[p] = v[p], v[c]
+		for r := c + 1; r < n; r++ {
+			f := a[r][c] / a[c][c]
+			for k := c; k < n; k++ {
+				a[r][k] -= f * a[c][k]
+			}
+			v[r] -= f * v[c]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		s := v[r]
+		for k := r + 1; k < n; k++ {
+			s -= a[r][k] * x[k]
+		}
+		x[r] = s / a[r][r]
+	}
+	return x, true
+}
+
+// GetWinFactors returns the win factors computed by winFactors.
+func (h *hotsContext) GetWinFactors(ctx context.Context, r *http.Request) (interface{}, error) {
+	init := h.getInit()
+	build := r.FormValue("build")
+	if build == "" {
+		return nil, errors.New("build required")
+	}
+	patch := init.config.Map["build"][build]
+	if patch == "" {
+		return nil, errors.Errorf("unrecognized build: %s", build)
+	}
+	gamemap := "0"
+	if m := r.FormValue("map"); m != "" {
+		gamemap = init.config.Map["map"][m]
+		if gamemap == "" {
+			return nil, errors.Errorf("unrecognized map: %s", m)
+		}
+	}
+	mode := r.FormValue("mode")
+	if mode == "" {
+		mode = "0"
+	}
+	var data []byte
+	if err := h.x.GetContext(ctx, &data, `
+		SELECT data
+		FROM winfactors
+		WHERE build = $1 AND map = $2 AND mode = $3
+		`, patch, gamemap, mode); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	tests := map[string]struct {
+		a      [][]float64
+		v      []float64
+		expect []float64
+	}{
+		"identity": {
+			a:      [][]float64{{1, 0}, {0, 1}},
+			v:      []float64{2, 3},
+			expect: []float64{2, 3},
+		},
+		"2x2": {
+			a:      [][]float64{{2, 1}, {1, 3}},
+			v:      []float64{3, 5},
+			expect: []float64{0.8, 1.4},
+		},
+		// A zero on the diagonal needs a row swap.
+		"pivot": {
+			a:      [][]float64{{0, 1}, {1, 0}},
+			v:      []float64{2, 3},
+			expect: []float64{3, 2},
+		},
+		"3x3": {
+			a:      [][]float64{{2, 1, -1}, {-3, -1, 2}, {-2, 1, 2}},
+			v:      []float64{8, -11, -3},
+			expect: []float64{2, 3, -1},
+		},
+		"singular": {
+			a: [][]float64{{1, 2}, {2, 4}},
+			v: []float64{1, 2},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, ok := solve(tc.a, tc.v)
+			if ok != (tc.expect != nil) {
+				t.Fatalf("expected ok %v, got %v", tc.expect != nil, ok)
+			}
+			for i := range tc.expect {
+				if math.Abs(res[i]-tc.expect[i]) > 1e-9 {
+					t.Fatalf("expected %v, got %v", tc.expect, res)
+				}
+			}
+		})
+	}
+}
+
+func TestLogisticRegression(t *testing.T) {
+	// grid returns x on a grid of two stats and y as the win probability
+	// of a logistic model with coefficients b.
+	grid := func(b []float64) ([][]float64, []float64) {
+		var x [][]float64
+		var y []float64
+		for i := -3; i <= 3; i++ {
+			for j := -3; j <= 3; j++ {
+				xi := []float64{float64(i), float64(j)}
+				x = append(x, xi)
+				y = append(y, 1/(1+math.Exp(-(b[0]*xi[0]+b[1]*xi[1]))))
+			}
+		}
+		return x, y
+	}
+	tests := map[string][]float64{
+		"zero":     {0, 0},
+		"one":      {1, 0},
+		"opposite": {0.5, -1},
+		"both":     {0.25, 0.75},
+	}
+	for name, expect := range tests {
+		t.Run(name, func(t *testing.T) {
+			x, y := grid(expect)
+			res := logisticRegression(x, y)
+			for i := range expect {
+				// The ridge penalty shrinks coefficients slightly.
+				if math.Abs(res[i]-expect[i]) > 1e-3 {
+					t.Fatalf("expected %v, got %v", expect, res)
+				}
+			}
+		})
+	}
+	t.Run("separable", func(t *testing.T) {
+		// The winner always had more of the stat. Without the ridge
+		// penalty the coefficient would grow without bound.
+		x := [][]float64{{-2}, {-1}, {1}, {2}}
+		y := []float64{0, 0, 1, 1}
+		res := logisticRegression(x, y)
+		if math.IsNaN(res[0]) || math.IsInf(res[0], 0) || res[0] <= 1 {
+			t.Fatalf("expected a large finite coefficient, got %v", res)
+		}
+	})
+}